@@ -7,6 +7,9 @@ go 1.16
 // replace go-hep.org/x/hep => /home/rmadar/cernbox/goDev/gohep_dev/hep
 
 require (
+	github.com/apache/arrow/go/v7 v7.0.1
 	go-hep.org/x/hep v0.30.1
+	golang.org/x/exp v0.0.0-20211216164055-b2b84827b756
 	gonum.org/v1/gonum v0.9.3
+	gonum.org/v1/plot v0.10.0
 )
@@ -0,0 +1,305 @@
+// Package expr compiles numeric expressions written with the Go syntax,
+// e.g. "l_pt>25 && abs(l_eta)<2.5" or "M(t+tbar)", into functions
+// evaluated on the current values of named variables and four-vectors.
+//
+// Numbers support the arithmetic (+, -, *, /), comparison and logical
+// (&&, ||, !) operators, booleans being 1 or 0, and the functions of
+// Funcs. Four-vectors can be added or subtracted, and give numbers with
+// the functions of VecFuncs and Vec2Funcs.
+package expr
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"strconv"
+
+	"go-hep.org/x/hep/fmom"
+)
+
+// Env holds the variables available in the expressions
+type Env struct {
+	Vars map[string]func() float64      // numbers
+	Vecs map[string]func() fmom.PxPyPzE // four-vectors
+}
+
+// Compiled expression, either a number or a four-vector
+type value struct {
+	num func() float64
+	vec func() fmom.PxPyPzE
+}
+
+// Funcs are the functions of numbers available in the expressions
+var Funcs = map[string]func(args ...float64) float64{
+	"abs":  func(x ...float64) float64 { return math.Abs(x[0]) },
+	"sqrt": func(x ...float64) float64 { return math.Sqrt(x[0]) },
+	"exp":  func(x ...float64) float64 { return math.Exp(x[0]) },
+	"log":  func(x ...float64) float64 { return math.Log(x[0]) },
+	"cos":  func(x ...float64) float64 { return math.Cos(x[0]) },
+	"sin":  func(x ...float64) float64 { return math.Sin(x[0]) },
+	"min":  func(x ...float64) float64 { return math.Min(x[0], x[1]) },
+	"max":  func(x ...float64) float64 { return math.Max(x[0], x[1]) },
+	"pow":  func(x ...float64) float64 { return math.Pow(x[0], x[1]) },
+}
+
+// Arity is the number of arguments of the functions of Funcs
+var Arity = map[string]int{
+	"abs": 1, "sqrt": 1, "exp": 1, "log": 1, "cos": 1, "sin": 1,
+	"min": 2, "max": 2, "pow": 2,
+}
+
+// VecFuncs are the functions of a four-vector available in the expressions
+var VecFuncs = map[string]func(p *fmom.PxPyPzE) float64{
+	"M":   (*fmom.PxPyPzE).M,
+	"pT":  (*fmom.PxPyPzE).Pt,
+	"eta": (*fmom.PxPyPzE).Eta,
+	"phi": (*fmom.PxPyPzE).Phi,
+	"y":   (*fmom.PxPyPzE).Rapidity,
+	"E":   (*fmom.PxPyPzE).E,
+	"px":  (*fmom.PxPyPzE).Px,
+	"py":  (*fmom.PxPyPzE).Py,
+	"pz":  (*fmom.PxPyPzE).Pz,
+}
+
+// Vec2Funcs are the functions of two four-vectors available in the expressions
+var Vec2Funcs = map[string]func(p1, p2 *fmom.PxPyPzE) float64{
+	"dphi": func(p1, p2 *fmom.PxPyPzE) float64 {
+		return math.Remainder(p1.Phi()-p2.Phi(), 2*math.Pi)
+	},
+	"dR": func(p1, p2 *fmom.PxPyPzE) float64 {
+		return math.Hypot(p1.Eta()-p2.Eta(), math.Remainder(p1.Phi()-p2.Phi(), 2*math.Pi))
+	},
+}
+
+// Scalar returns the function reading the number pointed to by ptr, or
+// nil if ptr does not point to a float64, float32 or int32
+func Scalar(ptr interface{}) func() float64 {
+	switch v := ptr.(type) {
+	case *float64:
+		return func() float64 { return *v }
+	case *float32:
+		return func() float64 { return float64(*v) }
+	case *int32:
+		return func() float64 { return float64(*v) }
+	}
+	return nil
+}
+
+// Parse checks the syntax of an expression
+func Parse(src string) (ast.Expr, error) {
+	return parser.ParseExpr(src)
+}
+
+// Number compiles an expression whose value is a number
+func Number(src string, env Env) (func() float64, error) {
+	node, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	x, err := env.compile(node)
+	if err != nil {
+		return nil, err
+	}
+	if x.num == nil {
+		return nil, fmt.Errorf("not a number")
+	}
+	return x.num, nil
+}
+
+func (env Env) compile(node ast.Expr) (value, error) {
+	switch n := node.(type) {
+
+	case *ast.ParenExpr:
+		return env.compile(n.X)
+
+	case *ast.BasicLit:
+		if n.Kind != token.INT && n.Kind != token.FLOAT {
+			return value{}, fmt.Errorf("invalid literal %s", n.Value)
+		}
+		v, err := strconv.ParseFloat(n.Value, 64)
+		if err != nil {
+			return value{}, err
+		}
+		return value{num: func() float64 { return v }}, nil
+
+	case *ast.Ident:
+		if v, ok := env.Vecs[n.Name]; ok {
+			return value{vec: v}, nil
+		}
+		v, ok := env.Vars[n.Name]
+		if !ok {
+			return value{}, fmt.Errorf("unknown variable %q", n.Name)
+		}
+		return value{num: v}, nil
+
+	case *ast.CallExpr:
+		return env.call(n)
+
+	case *ast.UnaryExpr:
+		x, err := env.compile(n.X)
+		if err != nil {
+			return value{}, err
+		}
+		switch {
+		case n.Op == token.ADD:
+			return x, nil
+		case n.Op == token.SUB && x.num != nil:
+			return value{num: func() float64 { return -x.num() }}, nil
+		case n.Op == token.NOT && x.num != nil:
+			return value{num: func() float64 { return b2f(x.num() == 0) }}, nil
+		}
+		return value{}, fmt.Errorf("unsupported operator %v", n.Op)
+
+	case *ast.BinaryExpr:
+		x, err := env.compile(n.X)
+		if err != nil {
+			return value{}, err
+		}
+		y, err := env.compile(n.Y)
+		if err != nil {
+			return value{}, err
+		}
+		if x.vec != nil || y.vec != nil {
+			return vecOp(n.Op, x, y)
+		}
+		return numOp(n.Op, x.num, y.num)
+	}
+
+	return value{}, fmt.Errorf("unsupported expression %T", node)
+}
+
+// Function call, of numbers or of four-vectors
+func (env Env) call(n *ast.CallExpr) (value, error) {
+	id, ok := n.Fun.(*ast.Ident)
+	if !ok {
+		return value{}, fmt.Errorf("unknown function %v", n.Fun)
+	}
+	args := make([]value, len(n.Args))
+	for i, arg := range n.Args {
+		x, err := env.compile(arg)
+		if err != nil {
+			return value{}, err
+		}
+		args[i] = x
+	}
+
+	// Functions of four-vectors
+	vecArgs := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s expects %d argument(s)", id.Name, n)
+		}
+		for _, x := range args {
+			if x.vec == nil {
+				return fmt.Errorf("%s expects four-vector argument(s)", id.Name)
+			}
+		}
+		return nil
+	}
+	if fct := VecFuncs[id.Name]; fct != nil {
+		if err := vecArgs(1); err != nil {
+			return value{}, err
+		}
+		return value{num: func() float64 {
+			p := args[0].vec()
+			return fct(&p)
+		}}, nil
+	}
+	if fct := Vec2Funcs[id.Name]; fct != nil {
+		if err := vecArgs(2); err != nil {
+			return value{}, err
+		}
+		return value{num: func() float64 {
+			p1, p2 := args[0].vec(), args[1].vec()
+			return fct(&p1, &p2)
+		}}, nil
+	}
+
+	// Functions of numbers
+	fct := Funcs[id.Name]
+	if fct == nil {
+		return value{}, fmt.Errorf("unknown function %v", n.Fun)
+	}
+	if len(args) != Arity[id.Name] {
+		return value{}, fmt.Errorf("%s expects %d argument(s)", id.Name, Arity[id.Name])
+	}
+	for _, x := range args {
+		if x.num == nil {
+			return value{}, fmt.Errorf("%s expects number argument(s)", id.Name)
+		}
+	}
+	return value{num: func() float64 {
+		x := make([]float64, len(args))
+		for i, a := range args {
+			x[i] = a.num()
+		}
+		return fct(x...)
+	}}, nil
+}
+
+// Sum and difference of four-vectors
+func vecOp(op token.Token, x, y value) (value, error) {
+	if x.vec == nil || y.vec == nil {
+		return value{}, fmt.Errorf("unsupported operator %v between a number and a four-vector", op)
+	}
+	var sign float64
+	switch op {
+	case token.ADD:
+		sign = 1
+	case token.SUB:
+		sign = -1
+	default:
+		return value{}, fmt.Errorf("unsupported operator %v between four-vectors", op)
+	}
+	return value{vec: func() fmom.PxPyPzE {
+		p1, p2 := x.vec(), y.vec()
+		return fmom.NewPxPyPzE(
+			p1.Px()+sign*p2.Px(),
+			p1.Py()+sign*p2.Py(),
+			p1.Pz()+sign*p2.Pz(),
+			p1.E()+sign*p2.E(),
+		)
+	}}, nil
+}
+
+// Arithmetic, comparison and logical operators between numbers
+func numOp(op token.Token, x, y func() float64) (value, error) {
+	var f func() float64
+	switch op {
+	case token.ADD:
+		f = func() float64 { return x() + y() }
+	case token.SUB:
+		f = func() float64 { return x() - y() }
+	case token.MUL:
+		f = func() float64 { return x() * y() }
+	case token.QUO:
+		f = func() float64 { return x() / y() }
+	case token.LSS:
+		f = func() float64 { return b2f(x() < y()) }
+	case token.LEQ:
+		f = func() float64 { return b2f(x() <= y()) }
+	case token.GTR:
+		f = func() float64 { return b2f(x() > y()) }
+	case token.GEQ:
+		f = func() float64 { return b2f(x() >= y()) }
+	case token.EQL:
+		f = func() float64 { return b2f(x() == y()) }
+	case token.NEQ:
+		f = func() float64 { return b2f(x() != y()) }
+	case token.LAND:
+		f = func() float64 { return b2f(x() != 0 && y() != 0) }
+	case token.LOR:
+		f = func() float64 { return b2f(x() != 0 || y() != 0) }
+	default:
+		return value{}, fmt.Errorf("unsupported operator %v", op)
+	}
+	return value{num: f}, nil
+}
+
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
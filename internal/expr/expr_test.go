@@ -0,0 +1,93 @@
+package expr
+
+import (
+	"math"
+	"testing"
+
+	"go-hep.org/x/hep/fmom"
+)
+
+func TestNumber(t *testing.T) {
+	var (
+		pt, eta = 30.0, float32(-1.5)
+		pid     = int32(-11)
+		p1      = fmom.NewPxPyPzE(30, 0, 40, 50)
+		p2      = fmom.NewPxPyPzE(-30, 0, 40, 50)
+		env     = Env{
+			Vars: map[string]func() float64{
+				"pt":  Scalar(&pt),
+				"eta": Scalar(&eta),
+				"pid": Scalar(&pid),
+			},
+			Vecs: map[string]func() fmom.PxPyPzE{
+				"p1": func() fmom.PxPyPzE { return p1 },
+				"p2": func() fmom.PxPyPzE { return p2 },
+			},
+		}
+	)
+	for _, tc := range []struct {
+		src  string
+		want float64
+	}{
+		{"pt", 30},
+		{"2*pt - 10/4", 57.5},
+		{"-(pt+1)", -31},
+		{"abs(eta)", 1.5},
+		{"pow(2, 3) + min(pt, 1) + max(pt, 1)", 39},
+		{"pt>25 && abs(eta)<2.5", 1},
+		{"pt>25 && !(abs(eta)<2.5)", 0},
+		{"pid == -11 || pid == 11", 1},
+		{"pt <= 30 && pt >= 30 && pt != 31", 1},
+		{"M(p1)", 0},
+		{"M(p1+p2)", 60},
+		{"pT(p1-p2)", 60},
+		{"E(p1+p2-p2)", 50},
+		{"dphi(p1, p2)", -math.Pi},
+		{"M(p1+p2) > pt", 1},
+	} {
+		f, err := Number(tc.src, env)
+		if err != nil {
+			t.Errorf("could not compile %q: %+v", tc.src, err)
+			continue
+		}
+		if got := f(); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("invalid value of %q: got %g, want %g", tc.src, got, tc.want)
+		}
+	}
+
+	// Values are read at each evaluation
+	f, err := Number("pt*2", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt = 10
+	if got := f(); got != 20 {
+		t.Errorf("invalid value after update: got %g, want 20", got)
+	}
+}
+
+func TestNumberErrors(t *testing.T) {
+	env := Env{
+		Vars: map[string]func() float64{"x": func() float64 { return 1 }},
+		Vecs: map[string]func() fmom.PxPyPzE{"p": func() fmom.PxPyPzE { return fmom.PxPyPzE{} }},
+	}
+	for _, src := range []string{
+		"x >",       // syntax
+		"y",         // unknown variable
+		"p",         // four-vector
+		"p + x",     // four-vector and number
+		"p * p",     // four-vector operator
+		"-p",        // four-vector operator
+		"foo(x)",    // unknown function
+		"abs(x, x)", // arity
+		"M(x)",      // number instead of four-vector
+		"sqrt(p)",   // four-vector instead of number
+		"dR(p)",     // arity
+		`"x"`,       // string
+		"x[0]",      // unsupported expression
+	} {
+		if _, err := Number(src, env); err == nil {
+			t.Errorf("expected an error for %q", src)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// Progress report, checkpointing and resumption of long conversions
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go-hep.org/x/hep/groot"
+	"go-hep.org/x/hep/groot/riofs"
+	"go-hep.org/x/hep/groot/rtree"
+	"go-hep.org/x/hep/lhef"
+)
+
+// countingReader counts the bytes consumed from the input file. It
+// implements io.ByteReader so that the XML decoder of the LHE reader
+// does not buffer ahead, which makes the count exact for LHE files.
+type countingReader struct {
+	br *bufio.Reader
+	n  int64
+}
+
+func newCountingReader(r io.Reader, offset int64) *countingReader {
+	return &countingReader{br: bufio.NewReader(r), n: offset}
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.br.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingReader) ReadByte() (byte, error) {
+	b, err := r.br.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+// Open a LHE decoder starting at offset, the input offset after the
+// last converted event (all events if offset is 0). The XML decoder
+// stops after the '<' of the </event> end tag: the remaining "/event>"
+// is read as character data, which the LHE decoder skips while looking
+// for the next event. The header and init block are still read from
+// the beginning of the file.
+func newLHEInput(f *os.File, offset int64) (*lhef.Decoder, *countingReader, error) {
+	cr := newCountingReader(f, 0)
+	dec, err := lhef.NewDecoder(cr)
+	if err != nil || offset == 0 {
+		return dec, cr, err
+	}
+
+	// Header, up to the end of the init block
+	hdr := make([]byte, cr.n)
+	_, err = f.ReadAt(hdr, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read LHE header: %w", err)
+	}
+
+	// Remaining events
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not seek to offset %d: %w", offset, err)
+	}
+
+	cr = newCountingReader(io.MultiReader(bytes.NewReader(hdr), f), offset-int64(len(hdr)))
+	dec, err = lhef.NewDecoder(cr)
+	return dec, cr, err
+}
+
+// Checkpoint of an interrupted conversion: number of processed events,
+// input offset after the last one and output chunks already closed
+type checkpoint struct {
+	Input  string   `json:"input"`
+	Events int      `json:"events"`
+	Offset int64    `json:"offset"`
+	Parts  []string `json:"parts"`
+
+	// Bookkeeping of the processed events
+	Passed  int           `json:"passed"`
+	SumW    float64       `json:"sumw"`
+	SumPass float64       `json:"sumw_passed"`
+	Bad     int           `json:"bad,omitempty"`
+	Checks  map[int32]int `json:"checks,omitempty"`
+}
+
+func readCheckpoint(fname string) (*checkpoint, error) {
+	raw, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var ck checkpoint
+	err = json.Unmarshal(raw, &ck)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode checkpoint %q: %w", fname, err)
+	}
+	return &ck, nil
+}
+
+// Write the checkpoint, replacing the previous one only once complete
+func (ck *checkpoint) write(fname string) error {
+	raw, err := json.MarshalIndent(ck, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(fname+".tmp", raw, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(fname+".tmp", fname)
+}
+
+// ROOT output written in chunks, each chunk being closed (hence
+// readable) at a checkpoint. The chunks are merged at the end: a ROOT
+// file cannot be reopened to append entries, and a file whose writer
+// was not closed (e.g. when the conversion is killed) is unreadable.
+type chunkWriter struct {
+	ofname string
+	tname  string
+	wvars  []rtree.WriteVar
+	run    *lhef.HEPRUP // init block stored in the merged file, if any
+	parts  []string
+
+	f  *riofs.File
+	tw rtree.Writer
+}
+
+func (w *chunkWriter) write() error {
+	if w.tw == nil {
+		fname := fmt.Sprintf("%s_part%03d.root", w.ofname[:len(w.ofname)-len(".root")], len(w.parts))
+		f, err := groot.Create(fname)
+		if err != nil {
+			return fmt.Errorf("could not create chunk %q: %w", fname, err)
+		}
+		tw, err := rtree.NewWriter(f, w.tname, w.wvars)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("could not create chunk tree-writer: %w", err)
+		}
+		w.f, w.tw = f, tw
+		w.parts = append(w.parts, fname)
+	}
+	_, err := w.tw.Write()
+	return err
+}
+
+// Close the current chunk, the next event opening a new one
+func (w *chunkWriter) flush() error {
+	if w.tw == nil {
+		return nil
+	}
+	err := w.tw.Close()
+	if err != nil {
+		return fmt.Errorf("could not close chunk tree-writer: %w", err)
+	}
+	err = w.f.Close()
+	w.f, w.tw = nil, nil
+	return err
+}
+
+// Close the last chunk and merge all of them into the output file
+func (w *chunkWriter) close() error {
+	err := w.flush()
+	if err != nil {
+		return err
+	}
+
+	fout, err := groot.Create(w.ofname)
+	if err != nil {
+		return err
+	}
+	defer fout.Close()
+	if w.run != nil {
+		writeRun(fout, w.tname+"_init", *w.run)
+	}
+	tw, err := rtree.NewWriter(fout, w.tname, w.wvars)
+	if err != nil {
+		return fmt.Errorf("could not create tree-writer: %w", err)
+	}
+	for _, fname := range w.parts {
+		err = copyTree(tw, fname, w.tname)
+		if err != nil {
+			return fmt.Errorf("could not merge chunk %q: %w", fname, err)
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return fmt.Errorf("could not close tree-writer: %w", err)
+	}
+	err = fout.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, fname := range w.parts {
+		os.Remove(fname)
+	}
+	return nil
+}
+
+// Append the entries of the tree stored in fname
+func copyTree(tw rtree.Writer, fname, tname string) error {
+	f, err := groot.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	obj, err := f.Get(tname)
+	if err != nil {
+		return err
+	}
+	tree, ok := obj.(rtree.Tree)
+	if !ok {
+		return fmt.Errorf("object %q is not a tree (%T)", tname, obj)
+	}
+	r, err := rtree.NewReader(tree, rtree.NewReadVars(tree))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = rtree.Copy(tw, r)
+	return err
+}
+
+// Periodic report of the conversion rate and of the remaining time,
+// estimated from the fraction of the input file already read
+type progress struct {
+	every time.Duration
+	size  int64
+
+	start, last time.Time
+	nEvt0       int
+	off0        int64
+}
+
+func newProgress(every time.Duration, size int64, nEvt int, off int64) *progress {
+	now := time.Now()
+	return &progress{every: every, size: size, start: now, last: now, nEvt0: nEvt, off0: off}
+}
+
+// Print the progress after nEvt events, the input being read up to off
+func (p *progress) update(nEvt int, off int64) {
+	if p.every <= 0 || time.Since(p.last) < p.every {
+		return
+	}
+	p.last = time.Now()
+
+	var (
+		dt   = p.last.Sub(p.start).Seconds()
+		rate = float64(nEvt-p.nEvt0) / dt
+		msg  = fmt.Sprintf(" --> %d events, %.0f events/s", nEvt, rate)
+	)
+
+	// The fraction of the input is unknown without its size (e.g. for
+	// a pipe), and the remaining time until some input has been read
+	if p.size > 0 {
+		msg += fmt.Sprintf(", %.1f%% of the input", 100*float64(off)/float64(p.size))
+		if off > p.off0 {
+			eta := time.Duration(float64(p.size-off) / (float64(off-p.off0) / dt) * float64(time.Second))
+			msg += fmt.Sprintf(", ETA %v", eta.Round(time.Second))
+		}
+	}
+	fmt.Println(msg)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var errKilled = errors.New("conversion killed")
+
+// Convert the LHE file ifname into ofname in chunks, with a checkpoint
+// every nckpt events, as done by main with -checkpoint (and -resume).
+// The conversion is killed, leaving the current chunk open, when it
+// reaches the event kill (never if 0).
+func convertChunked(t *testing.T, ifname, ofname string, nckpt, kill int, resume bool) error {
+	t.Helper()
+
+	var (
+		ckname = ofname + ".ckpt.json"
+		ck     = &checkpoint{Input: ifname}
+		err    error
+	)
+	if resume {
+		ck, err = readCheckpoint(ckname)
+		if err != nil {
+			t.Fatalf("could not read checkpoint: %+v", err)
+		}
+	}
+
+	f, err := os.Open(ifname)
+	if err != nil {
+		t.Fatalf("could not open LHE file: %+v", err)
+	}
+	defer f.Close()
+	dec, in, err := newLHEInput(f, ck.Offset)
+	if err != nil {
+		t.Fatalf("could not create LHE decoder: %+v", err)
+	}
+
+	var (
+		m      = &defaultMapping
+		lay    = Layout{}
+		e      = newEvent(m)
+		src    = &lheSource{dec: dec, m: m, lay: lay}
+		chunks = &chunkWriter{
+			ofname: ofname,
+			tname:  "truth",
+			wvars:  setBranches(&e, m, lay),
+			run:    &dec.Run,
+			parts:  ck.Parts,
+		}
+		nEvt = ck.Events
+	)
+	_, err = runPipeline(src, 4, in, func(evt Event, off int64) error {
+		nEvt++
+		if nEvt == kill {
+			return errKilled
+		}
+		e.assign(evt)
+		err := chunks.write()
+		if err != nil {
+			return err
+		}
+		if nEvt%nckpt != 0 {
+			return nil
+		}
+		err = chunks.flush()
+		if err != nil {
+			return err
+		}
+		ck.Events, ck.Offset, ck.Parts = nEvt, off, chunks.parts
+		return ck.write(ckname)
+	})
+	if err != nil {
+		return err
+	}
+	return chunks.close()
+}
+
+func TestCheckpointResume(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		ifname  = filepath.Join(dir, "big.lhe")
+		oneshot = filepath.Join(dir, "oneshot.root")
+		resumed = filepath.Join(dir, "resumed.root")
+	)
+	writeBigLHE(t, ifname, 500)
+	lhe2root(t, ifname, oneshot, "truth", 1)
+
+	// Killed after 250 events, the last checkpoint being at 192 events,
+	// then resumed from the checkpoint
+	err := convertChunked(t, ifname, resumed, 64, 250, false)
+	if !errors.Is(err, errKilled) {
+		t.Fatalf("expected the conversion to be killed, got %v", err)
+	}
+	ck, err := readCheckpoint(resumed + ".ckpt.json")
+	if err != nil {
+		t.Fatalf("could not read checkpoint: %+v", err)
+	}
+	if ck.Events != 192 || len(ck.Parts) != 3 {
+		t.Fatalf("invalid checkpoint: %d events in %d chunks, want 192 events in 3 chunks", ck.Events, len(ck.Parts))
+	}
+	err = convertChunked(t, ifname, resumed, 64, 0, true)
+	if err != nil {
+		t.Fatalf("could not resume conversion: %+v", err)
+	}
+
+	for _, tname := range []string{"truth", "truth_init"} {
+		if dumpTree(t, resumed, tname) != dumpTree(t, oneshot, tname) {
+			t.Errorf("tree %q of the resumed conversion differs from the one-shot conversion", tname)
+		}
+	}
+	parts, err := filepath.Glob(filepath.Join(dir, "resumed_part*.root"))
+	if err != nil || len(parts) != 0 {
+		t.Errorf("chunks left after the merge: %v", parts)
+	}
+}
@@ -0,0 +1,50 @@
+// Selection expressions evaluated on the converted events
+package main
+
+import (
+	"fmt"
+
+	"go-hep.org/x/hep/fmom"
+	"go-hep.org/x/hep/groot/rtree"
+
+	"github.com/rmadar/go-simple-examples/internal/expr"
+)
+
+// Compiled selection, e.g. "l_pt>25 && abs(l_eta)<2.5". Variables are
+// the scalar branches of the output tree, booleans are 1 or 0, and the
+// four-vectors of the particles are named after their slot, e.g.
+// "M(l+lbar)>20".
+type selection struct {
+	expr string
+	eval func() float64
+}
+
+// Compile a selection expression against the branches of the tree and
+// the particles of the event e
+func newSelection(src string, wvars []rtree.WriteVar, e *Event, m *Mapping) (*selection, error) {
+	env := expr.Env{
+		Vars: make(map[string]func() float64),
+		Vecs: make(map[string]func() fmom.PxPyPzE),
+	}
+	for _, wv := range wvars {
+		if v := expr.Scalar(wv.Value); v != nil {
+			env.Vars[wv.Name] = v
+		}
+	}
+	for i, s := range m.Slots {
+		p := &e.parts[i]
+		env.Vecs[s.Name] = func() fmom.PxPyPzE { return p.p4 }
+	}
+
+	eval, err := expr.Number(src, env)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selection %q: %w", src, err)
+	}
+
+	return &selection{expr: src, eval: eval}, nil
+}
+
+// Is the current event selected?
+func (s *selection) pass() bool {
+	return s.eval() != 0
+}
@@ -0,0 +1,261 @@
+// Output of the converted events in ROOT, Parquet, Arrow and CSV formats
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/v7/arrow"
+	"github.com/apache/arrow/go/v7/arrow/array"
+	"github.com/apache/arrow/go/v7/arrow/ipc"
+	"github.com/apache/arrow/go/v7/arrow/memory"
+	"github.com/apache/arrow/go/v7/parquet"
+	"github.com/apache/arrow/go/v7/parquet/compress"
+	"github.com/apache/arrow/go/v7/parquet/pqarrow"
+	"go-hep.org/x/hep/groot/riofs"
+	"go-hep.org/x/hep/groot/rtree"
+)
+
+// eventWriter stores the current event, as bound by the write variables
+type eventWriter interface {
+	write() error
+	close() error
+}
+
+// ROOT output
+type rootWriter struct {
+	f  *riofs.File
+	tw rtree.Writer
+}
+
+func (w *rootWriter) write() error {
+	_, err := w.tw.Write()
+	return err
+}
+
+func (w *rootWriter) close() error {
+	err := w.tw.Close()
+	if err != nil {
+		return fmt.Errorf("could not close tree-writer: %w", err)
+	}
+	return w.f.Close()
+}
+
+// Number of events per Arrow record batch (or Parquet row group)
+const arrowBatch = 10000
+
+// Arrow IPC file or Parquet output
+type arrowWriter struct {
+	f         *os.File
+	rb        *array.RecordBuilder
+	fill      []func(b array.Builder)
+	n         int
+	ipcw      *ipc.FileWriter
+	pqw       *pqarrow.FileWriter
+	isParquet bool
+}
+
+// Arrow schema corresponding to the write variables, with
+// the functions appending the current values to the builders
+func arrowSchema(wvars []rtree.WriteVar) (*arrow.Schema, []func(b array.Builder), error) {
+	var (
+		fields = make([]arrow.Field, len(wvars))
+		fill   = make([]func(b array.Builder), len(wvars))
+	)
+	for i, wv := range wvars {
+		var dt arrow.DataType
+		switch v := wv.Value.(type) {
+		case *float32:
+			dt = arrow.PrimitiveTypes.Float32
+			fill[i] = func(b array.Builder) { b.(*array.Float32Builder).Append(*v) }
+		case *float64:
+			dt = arrow.PrimitiveTypes.Float64
+			fill[i] = func(b array.Builder) { b.(*array.Float64Builder).Append(*v) }
+		case *int32:
+			dt = arrow.PrimitiveTypes.Int32
+			fill[i] = func(b array.Builder) { b.(*array.Int32Builder).Append(*v) }
+		case *[]int32:
+			dt = arrow.ListOf(arrow.PrimitiveTypes.Int32)
+			fill[i] = func(b array.Builder) {
+				lb := b.(*array.ListBuilder)
+				lb.Append(true)
+				lb.ValueBuilder().(*array.Int32Builder).AppendValues(*v, nil)
+			}
+		case *[]float64:
+			dt = arrow.ListOf(arrow.PrimitiveTypes.Float64)
+			fill[i] = func(b array.Builder) {
+				lb := b.(*array.ListBuilder)
+				lb.Append(true)
+				lb.ValueBuilder().(*array.Float64Builder).AppendValues(*v, nil)
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported type %T for branch %q", v, wv.Name)
+		}
+		fields[i] = arrow.Field{Name: wv.Name, Type: dt}
+	}
+	return arrow.NewSchema(fields, nil), fill, nil
+}
+
+func newArrowWriter(fname string, wvars []rtree.WriteVar, isParquet bool) (*arrowWriter, error) {
+	schema, fill, err := arrowSchema(wvars)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	mem := memory.NewGoAllocator()
+	w := &arrowWriter{
+		f:         f,
+		rb:        array.NewRecordBuilder(mem, schema),
+		fill:      fill,
+		isParquet: isParquet,
+	}
+	if isParquet {
+		props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+		w.pqw, err = pqarrow.NewFileWriter(schema, f, props, pqarrow.DefaultWriterProps())
+	} else {
+		w.ipcw, err = ipc.NewFileWriter(f, ipc.WithSchema(schema), ipc.WithAllocator(mem))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *arrowWriter) write() error {
+	for i, fill := range w.fill {
+		fill(w.rb.Field(i))
+	}
+	w.n++
+	if w.n < arrowBatch {
+		return nil
+	}
+	return w.flush()
+}
+
+// Write the pending events as a record batch
+func (w *arrowWriter) flush() error {
+	if w.n == 0 {
+		return nil
+	}
+	rec := w.rb.NewRecord()
+	defer rec.Release()
+	w.n = 0
+	if w.isParquet {
+		return w.pqw.Write(rec)
+	}
+	return w.ipcw.Write(rec)
+}
+
+func (w *arrowWriter) close() error {
+	defer w.rb.Release()
+	err := w.flush()
+	if err != nil {
+		return err
+	}
+	if w.isParquet {
+		err = w.pqw.Close()
+	} else {
+		err = w.ipcw.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	// The Parquet writer already closes the file
+	err = w.f.Close()
+	if err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// CSV output. Slices are stored as space separated values.
+type csvWriter struct {
+	f      *os.File
+	w      *csv.Writer
+	format []func() string
+	row    []string
+}
+
+func newCSVWriter(fname string, wvars []rtree.WriteVar) (*csvWriter, error) {
+	var (
+		header = make([]string, len(wvars))
+		format = make([]func() string, len(wvars))
+		ftoa   = func(x float64, bits int) string { return strconv.FormatFloat(x, 'g', -1, bits) }
+	)
+	for i, wv := range wvars {
+		header[i] = wv.Name
+		switch v := wv.Value.(type) {
+		case *float32:
+			format[i] = func() string { return ftoa(float64(*v), 32) }
+		case *float64:
+			format[i] = func() string { return ftoa(*v, 64) }
+		case *int32:
+			format[i] = func() string { return strconv.Itoa(int(*v)) }
+		case *[]int32:
+			format[i] = func() string {
+				s := make([]string, len(*v))
+				for j, x := range *v {
+					s[j] = strconv.Itoa(int(x))
+				}
+				return strings.Join(s, " ")
+			}
+		case *[]float64:
+			format[i] = func() string {
+				s := make([]string, len(*v))
+				for j, x := range *v {
+					s[j] = ftoa(x, 64)
+				}
+				return strings.Join(s, " ")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported type %T for branch %q", v, wv.Name)
+		}
+	}
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	w := &csvWriter{
+		f:      f,
+		w:      csv.NewWriter(f),
+		format: format,
+		row:    make([]string, len(wvars)),
+	}
+	err = w.w.Write(header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *csvWriter) write() error {
+	for i, format := range w.format {
+		w.row[i] = format()
+	}
+	return w.w.Write(w.row)
+}
+
+func (w *csvWriter) close() error {
+	w.w.Flush()
+	err := w.w.Error()
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
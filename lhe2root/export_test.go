@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow/go/v7/arrow"
+	"github.com/apache/arrow/go/v7/arrow/array"
+	"github.com/apache/arrow/go/v7/arrow/ipc"
+	"github.com/apache/arrow/go/v7/arrow/memory"
+	"github.com/apache/arrow/go/v7/parquet/pqarrow"
+	"go-hep.org/x/hep/groot/rtree"
+)
+
+func TestExport(t *testing.T) {
+	var (
+		pt   float32
+		wgt  float64
+		n    int32
+		ids  []int32
+		mass []float64
+	)
+	wvars := []rtree.WriteVar{
+		{Name: "pt", Value: &pt},
+		{Name: "wgt", Value: &wgt},
+		{Name: "n", Value: &n},
+		{Name: "ids", Value: &ids},
+		{Name: "mass", Value: &mass},
+	}
+	events := []func(){
+		func() { pt, wgt, n, ids, mass = 25.5, 1e-3, 2, []int32{6, -6}, []float64{172.5, 173.25} },
+		func() { pt, wgt, n, ids, mass = 0, -0.5, 0, []int32{}, []float64{} },
+		func() { pt, wgt, n, ids, mass = 1.25, 3, 1, []int32{5}, []float64{4.75} },
+	}
+	want := [][]string{
+		{"25.5", "0.001", "2", "6 -6", "172.5 173.25"},
+		{"0", "-0.5", "0", "", ""},
+		{"1.25", "3", "1", "5", "4.75"},
+	}
+
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		format string
+		create func(fname string) (eventWriter, error)
+		read   func(t *testing.T, fname string) [][]string
+	}{
+		{
+			format: "csv",
+			create: func(fname string) (eventWriter, error) { return newCSVWriter(fname, wvars) },
+			read:   readCSV,
+		},
+		{
+			format: "arrow",
+			create: func(fname string) (eventWriter, error) { return newArrowWriter(fname, wvars, false) },
+			read:   readArrow,
+		},
+		{
+			format: "parquet",
+			create: func(fname string) (eventWriter, error) { return newArrowWriter(fname, wvars, true) },
+			read:   readParquet,
+		},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			fname := filepath.Join(dir, "events."+tc.format)
+			w, err := tc.create(fname)
+			if err != nil {
+				t.Fatalf("could not create writer: %+v", err)
+			}
+			for _, set := range events {
+				set()
+				err = w.write()
+				if err != nil {
+					t.Fatalf("could not write event: %+v", err)
+				}
+			}
+			err = w.close()
+			if err != nil {
+				t.Fatalf("could not close writer: %+v", err)
+			}
+
+			got := tc.read(t, fname)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("invalid events:\ngot:  %q\nwant: %q", got, want)
+			}
+		})
+	}
+}
+
+func readCSV(t *testing.T, fname string) [][]string {
+	t.Helper()
+
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatalf("could not open CSV file: %+v", err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("could not read CSV file: %+v", err)
+	}
+	if got, want := strings.Join(rows[0], ","), "pt,wgt,n,ids,mass"; got != want {
+		t.Fatalf("invalid CSV header: got %q, want %q", got, want)
+	}
+	return rows[1:]
+}
+
+func readArrow(t *testing.T, fname string) [][]string {
+	t.Helper()
+
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatalf("could not open Arrow file: %+v", err)
+	}
+	defer f.Close()
+	r, err := ipc.NewFileReader(f)
+	if err != nil {
+		t.Fatalf("could not create Arrow reader: %+v", err)
+	}
+	defer r.Close()
+
+	var rows [][]string
+	for i := 0; i < r.NumRecords(); i++ {
+		rec, err := r.Record(i)
+		if err != nil {
+			t.Fatalf("could not read record %d: %+v", i, err)
+		}
+		rows = append(rows, recordRows(t, rec)...)
+	}
+	return rows
+}
+
+func readParquet(t *testing.T, fname string) [][]string {
+	t.Helper()
+
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatalf("could not open Parquet file: %+v", err)
+	}
+	defer f.Close()
+	mem := memory.NewGoAllocator()
+	tbl, err := pqarrow.ReadTable(context.Background(), f, nil, pqarrow.ArrowReadProperties{}, mem)
+	if err != nil {
+		t.Fatalf("could not read Parquet file: %+v", err)
+	}
+	defer tbl.Release()
+
+	var (
+		rows [][]string
+		tr   = array.NewTableReader(tbl, -1)
+	)
+	defer tr.Release()
+	for tr.Next() {
+		rows = append(rows, recordRows(t, tr.Record())...)
+	}
+	return rows
+}
+
+// Rows of a record, formatted as the CSV output
+func recordRows(t *testing.T, rec arrow.Record) [][]string {
+	t.Helper()
+
+	rows := make([][]string, rec.NumRows())
+	for i := range rows {
+		rows[i] = make([]string, rec.NumCols())
+	}
+	for j, col := range rec.Columns() {
+		for i := range rows {
+			rows[i][j] = formatValue(t, col, i)
+		}
+	}
+	return rows
+}
+
+// Value of the i-th entry of an array. The entries of lists are
+// separated by spaces.
+func formatValue(t *testing.T, arr arrow.Array, i int) string {
+	switch arr := arr.(type) {
+	case *array.Float32:
+		return strconv.FormatFloat(float64(arr.Value(i)), 'g', -1, 32)
+	case *array.Float64:
+		return strconv.FormatFloat(arr.Value(i), 'g', -1, 64)
+	case *array.Int32:
+		return strconv.Itoa(int(arr.Value(i)))
+	case *array.List:
+		var (
+			offsets = arr.Offsets()
+			s       []string
+		)
+		for k := offsets[i]; k < offsets[i+1]; k++ {
+			s = append(s, formatValue(t, arr.ListValues(), int(k)))
+		}
+		return strings.Join(s, " ")
+	}
+	t.Fatalf("unsupported array type %T", arr)
+	return ""
+}
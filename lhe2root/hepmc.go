@@ -0,0 +1,193 @@
+// Conversion of HepMC2 and HepMC3 ASCII events
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-hep.org/x/hep/fmom"
+	"go-hep.org/x/hep/hepmc"
+)
+
+// Status codes of the hard process: 3 for Pythia6/Herwig-like
+// records, 21-29 for Pythia8.
+var hardStatus = []int{3, 21, 22, 23, 24, 25, 26, 27, 28, 29}
+
+// HepMC event source
+type hepmcSource struct {
+	dec     *hepmc.Decoder
+	m       *Mapping
+	lay     Layout
+	status  map[int]bool
+	verbose bool
+}
+
+// Is the file a HepMC (2 or 3) ASCII file? HepMC files start with a
+// "HepMC::Version" or "HepMC::Asciiv3" line, other files are taken as
+// LHE files. The file is read again from its beginning afterwards.
+func detectHepMC(f io.ReadSeeker) (bool, error) {
+	var (
+		sc    = bufio.NewScanner(f)
+		first string
+	)
+	for sc.Scan() {
+		if first = strings.TrimSpace(sc.Text()); first != "" {
+			break
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return false, fmt.Errorf("could not read header: %w", err)
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(first, "HepMC::"), nil
+}
+
+// Create a HepMC2 or HepMC3 event source, depending on the file header
+func newHepMCSource(r io.Reader, m *Mapping, lay Layout, status string, verbose bool) (source, error) {
+	codes, err := parseStatus(status)
+	if err != nil {
+		return nil, err
+	}
+	sel := make(map[int]bool)
+	for _, c := range codes {
+		sel[c] = true
+	}
+
+	br := bufio.NewReader(r)
+	hdr, err := br.Peek(256)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("could not read HepMC header: %w", err)
+	}
+	if bytes.Contains(hdr, []byte(hepmc3Start)) {
+		return newHepMC3Source(br, m, lay, sel, verbose), nil
+	}
+
+	src := &hepmcSource{
+		dec:     hepmc.NewDecoder(br),
+		m:       m,
+		lay:     lay,
+		status:  sel,
+		verbose: verbose,
+	}
+	return src, nil
+}
+
+// Get the list of status codes from its command line
+// specification: hard, final or a comma separated list
+func parseStatus(spec string) ([]int, error) {
+	switch spec {
+	case "hard":
+		return hardStatus, nil
+	case "final":
+		return []int{1}, nil
+	}
+
+	var codes []int
+	for _, tok := range strings.Split(spec, ",") {
+		c, err := strconv.Atoi(strings.TrimSpace(tok))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q: %w", tok, err)
+		}
+		codes = append(codes, c)
+	}
+	return codes, nil
+}
+
+func (s *hepmcSource) next() (interface{}, error) {
+	var evt hepmc.Event
+	err := s.dec.Decode(&evt)
+	if err != nil {
+		return nil, err
+	}
+	if s.verbose {
+		fmt.Println()
+		err = evt.Print(os.Stdout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &evt, nil
+}
+
+func (s *hepmcSource) convert(raw interface{}) (Event, error) {
+	var (
+		evt = raw.(*hepmc.Event)
+		e   = newEvent(s.m)
+	)
+
+	// Momenta are stored in GeV
+	scale := 1.0
+	if evt.MomentumUnit == hepmc.MEV {
+		scale = 1e-3
+	}
+	get4Vec := func(p *hepmc.Particle) fmom.PxPyPzE {
+		return fmom.NewPxPyPzE(
+			scale*p.Momentum.Px(),
+			scale*p.Momentum.Py(),
+			scale*p.Momentum.Pz(),
+			scale*p.Momentum.E(),
+		)
+	}
+
+	// Event weight
+	if len(evt.Weights.Slice) > 0 {
+		e.w = evt.Weights.Slice[0]
+	}
+
+	// Incoming partons of the signal vertex, if any
+	if vtx := evt.SignalVertex; vtx != nil && len(vtx.ParticlesIn) == 2 {
+		p1, p2 := vtx.ParticlesIn[0], vtx.ParticlesIn[1]
+		e.i1p = p4Array(get4Vec(p1))
+		e.i1id = int32(p1.PdgID)
+		e.i2p = p4Array(get4Vec(p2))
+		e.i2id = int32(p2.PdgID)
+	}
+
+	// Particles are visited in barcode order for reproducibility,
+	// then by decreasing pT so that the leading candidates fill the slots.
+	var (
+		bcs     = make([]int, 0, len(evt.Particles))
+		cands   = make([]candidate, 0, len(evt.Particles))
+		parents = func(bc int) []int {
+			var ps []int
+			if vtx := evt.Particles[bc].ProdVertex; vtx != nil {
+				for _, p := range vtx.ParticlesIn {
+					ps = append(ps, p.Barcode)
+				}
+			}
+			return ps
+		}
+		pidOf = func(bc int) int64 { return evt.Particles[bc].PdgID }
+	)
+	for bc := range evt.Particles {
+		bcs = append(bcs, bc)
+	}
+	sort.Ints(bcs)
+	for _, bc := range bcs {
+		bc := bc
+		p := evt.Particles[bc]
+		if !s.m.wants(p.PdgID) {
+			continue
+		}
+		cands = append(cands, candidate{
+			pid:       p.PdgID,
+			status:    p.Status,
+			p4:        get4Vec(p),
+			ancestors: func() []int64 { return ancestorIDs(bc, parents, pidOf) },
+		})
+	}
+	sortByPt(cands)
+	s.m.fill(&e, cands, s.status)
+	e.project(s.lay)
+
+	return e, nil
+}
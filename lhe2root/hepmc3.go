@@ -0,0 +1,255 @@
+// Reader of HepMC3 ASCII events (not handled by go-hep/hepmc)
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"go-hep.org/x/hep/fmom"
+)
+
+const (
+	hepmc3Start = "HepMC::Asciiv3-START_EVENT_LISTING"
+	hepmc3End   = "HepMC::Asciiv3-END_EVENT_LISTING"
+)
+
+// Minimal HepMC3 event: only what is needed to fill the TTree
+type hepmc3Event struct {
+	number    int
+	weights   []float64
+	gev       bool
+	particles []hepmc3Particle
+	vertices  map[int][]int // incoming particles of each vertex
+}
+
+type hepmc3Particle struct {
+	id     int
+	parent int // parent particle if positive, production vertex if negative
+	pid    int64
+	status int
+	p      [4]float64
+}
+
+// HepMC3 event source
+type hepmc3Source struct {
+	sc      *bufio.Scanner
+	m       *Mapping
+	lay     Layout
+	status  map[int]bool
+	verbose bool
+	line    string // first line of the next event, already read
+	started bool
+}
+
+func newHepMC3Source(r io.Reader, m *Mapping, lay Layout, status map[int]bool, verbose bool) *hepmc3Source {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &hepmc3Source{sc: sc, m: m, lay: lay, status: status, verbose: verbose}
+}
+
+func (s *hepmc3Source) next() (interface{}, error) {
+
+	// Skip the run header, up to the first event
+	if !s.started {
+		for s.sc.Scan() {
+			line := s.sc.Text()
+			if strings.HasPrefix(line, "E ") {
+				s.line = line
+				break
+			}
+		}
+		s.started = true
+	}
+	if s.line == "" {
+		if err := s.sc.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	evt := &hepmc3Event{gev: true, vertices: make(map[int][]int)}
+	toks := strings.Fields(s.line)
+	n, err := strconv.Atoi(toks[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid HepMC3 event line %q: %w", s.line, err)
+	}
+	evt.number = n
+	s.line = ""
+
+	// Read the event content, up to the next event
+	for s.sc.Scan() {
+		line := s.sc.Text()
+		if line == "" {
+			continue
+		}
+		toks := strings.Fields(line)
+		switch toks[0] {
+		case "E":
+			s.line = line
+		case "U":
+			evt.gev = len(toks) > 1 && toks[1] == "GEV"
+		case "W":
+			for _, tok := range toks[1:] {
+				w, err := strconv.ParseFloat(tok, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid HepMC3 weight %q: %w", tok, err)
+				}
+				evt.weights = append(evt.weights, w)
+			}
+		case "P":
+			p, err := parseHepMC3Particle(toks)
+			if err != nil {
+				return nil, fmt.Errorf("invalid HepMC3 particle line %q: %w", line, err)
+			}
+			evt.particles = append(evt.particles, p)
+		case "V":
+			id, in, err := parseHepMC3Vertex(toks)
+			if err != nil {
+				return nil, fmt.Errorf("invalid HepMC3 vertex line %q: %w", line, err)
+			}
+			evt.vertices[id] = in
+		}
+		if s.line != "" || toks[0] == hepmc3End {
+			break
+		}
+	}
+	if err := s.sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if s.verbose {
+		fmt.Println()
+		fmt.Printf("HepMC3 event %d: %d particles, weights=%v\n", evt.number, len(evt.particles), evt.weights)
+	}
+
+	return evt, nil
+}
+
+// Particle line: P id parent pid px py pz e m status
+func parseHepMC3Particle(toks []string) (hepmc3Particle, error) {
+	var (
+		p   hepmc3Particle
+		err error
+	)
+	if len(toks) < 10 {
+		return p, fmt.Errorf("expected 10 fields, got %d", len(toks))
+	}
+	p.id, err = strconv.Atoi(toks[1])
+	if err != nil {
+		return p, err
+	}
+	p.parent, err = strconv.Atoi(toks[2])
+	if err != nil {
+		return p, err
+	}
+	p.pid, err = strconv.ParseInt(toks[3], 10, 64)
+	if err != nil {
+		return p, err
+	}
+	for i := range p.p {
+		p.p[i], err = strconv.ParseFloat(toks[4+i], 64)
+		if err != nil {
+			return p, err
+		}
+	}
+	p.status, err = strconv.Atoi(toks[9])
+	return p, err
+}
+
+// Vertex line: V id status [in1,in2,...] [@ x y z t]
+func parseHepMC3Vertex(toks []string) (int, []int, error) {
+	if len(toks) < 4 {
+		return 0, nil, fmt.Errorf("expected at least 4 fields, got %d", len(toks))
+	}
+	id, err := strconv.Atoi(toks[1])
+	if err != nil {
+		return 0, nil, err
+	}
+	var in []int
+	for _, tok := range strings.Split(strings.Trim(toks[3], "[]"), ",") {
+		if tok == "" {
+			continue
+		}
+		i, err := strconv.Atoi(tok)
+		if err != nil {
+			return 0, nil, err
+		}
+		in = append(in, i)
+	}
+	return id, in, nil
+}
+
+func (s *hepmc3Source) convert(raw interface{}) (Event, error) {
+	var (
+		evt = raw.(*hepmc3Event)
+		e   = newEvent(s.m)
+	)
+
+	// Momenta are stored in GeV
+	scale := 1.0
+	if !evt.gev {
+		scale = 1e-3
+	}
+
+	// Event weight
+	if len(evt.weights) > 0 {
+		e.w = evt.weights[0]
+	}
+
+	// Ancestry, with particles identified by their id
+	var (
+		index   = make(map[int]int, len(evt.particles))
+		parents = func(id int) []int {
+			p := evt.particles[index[id]]
+			switch {
+			case p.parent > 0:
+				return []int{p.parent}
+			case p.parent < 0:
+				return evt.vertices[p.parent]
+			}
+			return nil
+		}
+		pidOf = func(id int) int64 { return evt.particles[index[id]].pid }
+		cands = make([]candidate, 0, len(evt.particles))
+	)
+	for i, p := range evt.particles {
+		index[p.id] = i
+	}
+
+	nin := 0
+	for _, p := range evt.particles {
+		p := p
+		P := fmom.NewPxPyPzE(scale*p.p[0], scale*p.p[1], scale*p.p[2], scale*p.p[3])
+
+		// Incoming partons of the hard process (Pythia8 convention)
+		if p.status == 21 {
+			switch nin {
+			case 0:
+				e.i1p = p4Array(P)
+				e.i1id = int32(p.pid)
+			case 1:
+				e.i2p = p4Array(P)
+				e.i2id = int32(p.pid)
+			}
+			nin++
+		}
+
+		if !s.m.wants(p.pid) {
+			continue
+		}
+		cands = append(cands, candidate{
+			pid:       p.pid,
+			status:    p.status,
+			p4:        P,
+			ancestors: func() []int64 { return ancestorIDs(p.id, parents, pidOf) },
+		})
+	}
+	sortByPt(cands)
+	s.m.fill(&e, cands, s.status)
+	e.project(s.lay)
+
+	return e, nil
+}
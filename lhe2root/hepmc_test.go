@@ -0,0 +1,208 @@
+package main
+
+import (
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectHepMC(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		want   bool
+	}{
+		{"HepMC::Version 2.06.09\nHepMC::IO_GenEvent-START_EVENT_LISTING\n", true},
+		{"\nHepMC::Version 3.02.05\nHepMC::Asciiv3-START_EVENT_LISTING\n", true},
+		{"HepMC::Asciiv3-START_EVENT_LISTING\n", true},
+		{"<LesHouchesEvents version=\"1.0\">\n<init>\n", false},
+		{"", false},
+	} {
+		r := strings.NewReader(tc.header)
+		got, err := detectHepMC(r)
+		if err != nil {
+			t.Fatalf("could not detect the format of %q: %+v", tc.header, err)
+		}
+		if got != tc.want {
+			t.Errorf("invalid format of %q: got HepMC=%v, want %v", tc.header, got, tc.want)
+		}
+		if r.Len() != len(tc.header) {
+			t.Errorf("the reader of %q was not rewound", tc.header)
+		}
+	}
+}
+
+// g b -> t W-, t -> W+ b, W+ -> e+ nu_e, W- -> mu- nu_mu~ in the HepMC2
+// format, with the final state copies of the b, mu- and e+ (status 1).
+// The px of each particle is its barcode and py sets the pT ordering.
+const hepmc2TW = `HepMC::Version 2.06.09
+HepMC::IO_GenEvent-START_EVENT_LISTING
+E 1 -1 -1 -1 -1 0 -3 9 1 2 0 1 0.25
+U GEV MM
+V -1 0 0 0 0 0 1 1 0
+P 1 2212 0 0 6500 6500 0 4 0 0 -1 0
+P 3 21 3 0 100 110 0 21 0 0 -3 0
+V -2 0 0 0 0 0 1 1 0
+P 2 2212 0 0 -6500 6500 0 4 0 0 -2 0
+P 4 5 4 500 -100 510 0 21 0 0 -3 0
+V -3 0 0 0 0 0 0 2 0
+P 5 6 5 200 0 210 0 22 0 0 -4 0
+P 6 -24 6 150 0 160 0 22 0 0 -5 0
+V -4 0 0 0 0 0 0 2 0
+P 7 24 7 120 0 130 0 22 0 0 -6 0
+P 8 5 8 60 0 70 0 23 0 0 -7 0
+V -5 0 0 0 0 0 0 2 0
+P 9 13 9 80 0 90 0 23 0 0 -8 0
+P 10 -14 10 70 0 80 0 23 0 0 0 0
+V -6 0 0 0 0 0 0 2 0
+P 11 -11 11 40 0 50 0 23 0 0 -9 0
+P 12 12 12 30 0 40 0 23 0 0 0 0
+V -7 0 0 0 0 0 0 1 0
+P 13 5 13 55 0 65 0 1 0 0 0 0
+V -8 0 0 0 0 0 0 1 0
+P 14 13 14 78 0 88 0 1 0 0 0 0
+V -9 0 0 0 0 0 0 1 0
+P 15 -11 15 38 0 48 0 1 0 0 0 0
+HepMC::IO_GenEvent-END_EVENT_LISTING
+`
+
+// Same event in the HepMC3 format
+const hepmc3TW = `HepMC::Version 3.02.05
+HepMC::Asciiv3-START_EVENT_LISTING
+E 1 9 15
+U GEV MM
+W 0.25
+P 1 0 2212 0 0 6500 6500 0 4
+P 2 0 2212 0 0 -6500 6500 0 4
+P 3 1 21 3 0 100 110 0 21
+P 4 2 5 4 500 -100 510 0 21
+V -3 0 [3,4]
+P 5 -3 6 5 200 0 210 0 22
+P 6 -3 -24 6 150 0 160 0 22
+P 7 5 24 7 120 0 130 0 22
+P 8 5 5 8 60 0 70 0 23
+P 9 6 13 9 80 0 90 0 23
+P 10 6 -14 10 70 0 80 0 23
+P 11 7 -11 11 40 0 50 0 23
+P 12 7 12 12 30 0 40 0 23
+P 13 8 5 13 55 0 65 0 1
+P 14 9 13 14 78 0 88 0 1
+P 15 11 -11 15 38 0 48 0 1
+HepMC::Asciiv3-END_EVENT_LISTING
+`
+
+// g g -> t tbar H, t -> W+ b, tbar -> W- bbar, H -> b bbar, W+ -> e+ nu_e,
+// W- -> d u~ in the HepMC3 format, in MeV
+const hepmc3TTH = `HepMC::Version 3.02.05
+HepMC::Asciiv3-START_EVENT_LISTING
+E 1 1 17
+U MEV MM
+W 2.5 1.0
+P 1 0 2212 0 0 6500000 6500000 0 4
+P 2 0 2212 0 0 -6500000 6500000 0 4
+P 3 1 21 3000 0 100000 110000 0 21
+P 4 2 21 4000 0 -100000 110000 0 21
+V -3 0 [3,4]
+P 5 -3 6 5000 200000 0 210000 0 22
+P 6 -3 -6 6000 190000 0 200000 0 22
+P 7 -3 25 7000 180000 0 190000 0 22
+P 8 5 24 8000 120000 0 130000 0 22
+P 9 5 5 9000 100000 0 110000 0 23
+P 10 6 -24 10000 110000 0 120000 0 22
+P 11 6 -5 11000 90000 0 100000 0 23
+P 12 7 5 12000 150000 0 160000 0 23
+P 13 7 -5 13000 140000 0 150000 0 23
+P 14 8 -11 14000 40000 0 50000 0 1
+P 15 8 12 15000 30000 0 40000 0 1
+P 16 10 1 16000 60000 0 70000 0 1
+P 17 10 -2 17000 70000 0 80000 0 1
+HepMC::Asciiv3-END_EVENT_LISTING
+`
+
+func TestHepMCConvert(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		input   string
+		mapping string
+		status  string
+		w       float64
+		in      [2]int32
+		want    map[string]int // barcode (or id) of the particle of each slot, if any
+	}{
+		{
+			name: "HepMC2 tW hard", input: hepmc2TW, mapping: "tW_dilepton.json", status: "hard",
+			w: 0.25, in: [2]int32{21, 5},
+			want: map[string]int{"t": 5, "b": 8, "W_t": 7, "W": 6, "l_t": 11, "v_t": 12, "l_W": 9, "v_W": 10},
+		},
+		{
+			name: "HepMC2 tW final", input: hepmc2TW, mapping: "tW_dilepton.json", status: "final",
+			w: 0.25, in: [2]int32{21, 5},
+			want: map[string]int{"b": 13, "l_t": 15, "l_W": 14},
+		},
+		{
+			name: "HepMC2 tW status list", input: hepmc2TW, mapping: "tW_dilepton.json", status: "22",
+			w: 0.25, in: [2]int32{21, 5},
+			want: map[string]int{"t": 5, "W_t": 7, "W": 6},
+		},
+		{
+			name: "HepMC3 tW hard", input: hepmc3TW, mapping: "tW_dilepton.json", status: "hard",
+			w: 0.25, in: [2]int32{21, 5},
+			want: map[string]int{"t": 5, "b": 8, "W_t": 7, "W": 6, "l_t": 11, "v_t": 12, "l_W": 9, "v_W": 10},
+		},
+		{
+			name: "HepMC3 tW final", input: hepmc3TW, mapping: "tW_dilepton.json", status: "final",
+			w: 0.25, in: [2]int32{21, 5},
+			want: map[string]int{"b": 13, "l_t": 15, "l_W": 14},
+		},
+		{
+			name: "HepMC3 ttH hard", input: hepmc3TTH, mapping: "ttH_semilep.json", status: "hard",
+			w: 2.5, in: [2]int32{21, 21},
+			want: map[string]int{
+				"t": 5, "tbar": 6, "H": 7, "b_t": 9, "bbar_t": 11, "b_H": 12, "bbar_H": 13,
+				"lep": 14, "nu": 15, "q1": 17, "q2": 16,
+			},
+		},
+	} {
+		m, err := readMapping(filepath.Join("mappings", tc.mapping))
+		if err != nil {
+			t.Fatalf("could not read mapping: %+v", err)
+		}
+		src, err := newHepMCSource(strings.NewReader(tc.input), m, Layout{}, tc.status, false)
+		if err != nil {
+			t.Fatalf("%s: could not create HepMC source: %+v", tc.name, err)
+		}
+		raw, err := src.next()
+		if err != nil {
+			t.Fatalf("%s: could not decode event: %+v", tc.name, err)
+		}
+		e, err := src.convert(raw)
+		if err != nil {
+			t.Fatalf("%s: could not convert event: %+v", tc.name, err)
+		}
+		if _, err := src.next(); err != io.EOF {
+			t.Errorf("%s: expected the end of the file, got %v", tc.name, err)
+		}
+
+		if e.w != tc.w {
+			t.Errorf("%s: invalid weight: got %g, want %g", tc.name, e.w, tc.w)
+		}
+		if e.i1id != tc.in[0] || e.i2id != tc.in[1] {
+			t.Errorf("%s: invalid incoming partons: got (%d, %d), want %v", tc.name, e.i1id, e.i2id, tc.in)
+		}
+		for i, s := range m.Slots {
+			// The px of the particles, in GeV, is their barcode
+			var (
+				p    = e.parts[i]
+				got  = int(math.Round(p.p4.Px()))
+				want = tc.want[s.Name]
+			)
+			if p.pid == 0 {
+				got = 0
+			}
+			if got != want {
+				t.Errorf("%s: slot %q: got particle %d, want %d", tc.name, s.Name, got, want)
+			}
+		}
+	}
+}
@@ -4,251 +4,622 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"go-hep.org/x/hep/groot"
+	"go-hep.org/x/hep/groot/riofs"
 	"go-hep.org/x/hep/groot/rtree"
-	"go-hep.org/x/hep/heppdt"
 	"go-hep.org/x/hep/lhef"
 	"go-hep.org/x/hep/fmom"
 )
 
-// Event stucture for partonic ttbar->dilepton event
+// Event stucture for partonic events, ttbar->dilepton by default
 type Event struct {
 
 	// Weight
 	w float64
 	
-	// Initial state
-	i1pz, i2pz float64
+	// Initial state, with (px, py, pz, E) four-vectors
+	i1p, i2p   [4]float64
 	i1id, i2id int32
 	i1h, i2h   float64
 
-	// Final state
-	t, tbar  Particle
-	b, bbar  Particle
-	W, Wbar  Particle
-	l, lbar  Particle
-	v, vbar  Particle
+	// Final state, one particle per slot of the mapping
+	parts []Particle
+
+	// Raw LHE record, used for the back-conversion to LHE
+	lhe Record
+
+	// Failed validation checks
+	bad int32
+}
+
+// Record stores the full HEPEUP block of an event, with
+// one slice per particle attribute
+type Record struct {
+	nup    int32
+	idprup int32
+	scalup float64
+	aqedup float64
+	aqcdup float64
+	idup   []int32
+	istup  []int32
+	moth1  []int32
+	moth2  []int32
+	icol1  []int32
+	icol2  []int32
+	px     []float64
+	py     []float64
+	pz     []float64
+	e      []float64
+	m      []float64
+	vtimup []float64
+	spinup []float64
+}
+
+// Run stores the HEPRUP init block of the LHE file
+type Run struct {
+	idbmup [2]int32
+	ebmup  [2]float64
+	pdfgup [2]int32
+	pdfsup [2]int32
+	idwtup int32
+	nprup  int32
+	xsecup []float64
+	xerrup []float64
+	xmaxup []float64
+	lprup  []int32
 }
 
 type Particle struct {
-	pt  float32
-	eta float32
-	phi float32
-	m   float32
+	p4  fmom.PxPyPzE
 	pid int32
+
+	// Four-vector components stored in the tree, see Layout
+	c   [4]float64
+	c32 [4]float32
+}
+
+// Layout defines how the particle four-vectors are stored
+type Layout struct {
+	PxPyPzE bool // (px, py, pz, E) instead of (pt, eta, phi, m) components
+	F64     bool // float64 instead of float32 components
+}
+
+// Names of the four-vector components
+func (l Layout) names() [4]string {
+	if l.PxPyPzE {
+		return [4]string{"px", "py", "pz", "e"}
+	}
+	return [4]string{"pt", "eta", "phi", "m"}
+}
+
+// Compute the stored components from the four-vector
+func (p *Particle) project(l Layout) {
+	switch {
+	case p.pid == 0:
+		p.c = [4]float64{}
+	case l.PxPyPzE:
+		p.c = [4]float64{p.p4.Px(), p.p4.Py(), p.p4.Pz(), p.p4.E()}
+	default:
+		p.c = [4]float64{p.p4.Pt(), p.p4.Eta(), p.p4.Phi(), p.p4.M()}
+	}
+	for i, v := range p.c {
+		p.c32[i] = float32(v)
+	}
 }
 
 func main() {
 
 	// Input arguments
-	ifname := flag.String("f", "ttbar_0j_parton.lhe", "Path to the input LHE file")
+	ifname := flag.String("f", "ttbar_0j_parton.lhe", "Path to the input LHE or HepMC (2 or 3) ASCII file, the format being detected from its first line")
 	tname := flag.String("t", "truth", "Name of the created TTree")
 	verbose := flag.Bool("v", false, "Enable verbose mode")
+	reverse := flag.Bool("r", false, "Convert a ROOT file (-f) back into a LHE file")
+	nworkers := flag.Int("j", runtime.NumCPU(), "Number of concurrent conversion workers")
+	validate := flag.String("validate", "", "Check LHE events consistency and flag (flag) or drop (drop) bad events")
+	tol := flag.Float64("tol", 1e-6, "Relative tolerance of the momentum and mass checks")
+	mapFile := flag.String("map", "", "JSON file describing the particle to branch mapping (default: ttbar dilepton)")
+	repr := flag.String("repr", "ptetaphim", "Four-vector representation of the particles: ptetaphim or pxpypze")
+	f64 := flag.Bool("f64", false, "Store the particle four-vectors in double precision")
+	format := flag.String("format", "root", "Comma separated list of output formats: root, parquet, arrow, csv")
+	cut := flag.String("cut", "", "Selection applied before writing events, e.g. \"l_pt>25 && abs(l_eta)<2.5\"")
+	status := flag.String("status", "hard", "HepMC particles to consider: hard, final or a list of status codes (e.g. 1,2)")
+	every := flag.Duration("progress", 10*time.Second, "Interval between progress reports (0 to disable)")
+	nckpt := flag.Int("checkpoint", 0, "Number of events between checkpoints, the ROOT output being written in chunks <output>_partNNN.root merged at the end (0 to disable)")
+	resume := flag.Bool("resume", false, "Resume an interrupted conversion from its last checkpoint, keeping the chunks it already closed")
 	flag.Parse()
 
-	// Prepare the outfile and tree
-	ofname := strings.ReplaceAll(*ifname, ".lhe", ".root")
-	fout, err := groot.Create(ofname)
-	if err != nil {
-		log.Fatalf("could not create ROOT file %q: %w", ofname, err)
-	}
-	defer fout.Close()
-	var e Event
-	wvars := setBranches(&e)
-	tw, err := rtree.NewWriter(fout, *tname, wvars)
-	if err != nil {
-		log.Fatalf("could not create scanner: %+v", err)
+	// Back-conversion ROOT -> LHE
+	if *reverse {
+		root2lhe(*ifname, *tname, *verbose)
+		return
 	}
-	defer tw.Close()
 
-	// Load LHE file
+	// Load input file
 	f, err := os.Open(*ifname)
 	if err != nil {
 		panic(err)
 	}
 	defer f.Close()
 
-	// Get LHE decoder
-	lhedec, err := lhef.NewDecoder(f)
+	// Input format, from the first line of the file
+	isHepMC, err := detectHepMC(f)
 	if err != nil {
-		panic(err)
+		log.Fatalf("could not detect the format of %q: %+v", *ifname, err)
 	}
 
-	// Loop over events
-	iEvt := 0
-	
-loop:
-	for {
-		
-		// Decode this event, stop if the end of file is reached
-		lheEvt, err := lhedec.Decode()
+	// Particle to branch mapping
+	m := &defaultMapping
+	if *mapFile != "" {
+		m, err = readMapping(*mapFile)
 		if err != nil {
-			if err == io.EOF {
-				break loop
+			log.Fatalf("could not read particle mapping: %+v", err)
+		}
+	}
+
+	// Storage of the four-vectors
+	lay := Layout{F64: *f64}
+	switch *repr {
+	case "ptetaphim":
+	case "pxpypze":
+		lay.PxPyPzE = true
+	default:
+		log.Fatalf("unknown four-vector representation %q (expected ptetaphim or pxpypze)", *repr)
+	}
+
+	// Validation of the events
+	var val *validator
+	if *validate != "" {
+		if isHepMC {
+			log.Fatalf("validation is only available for LHE input")
+		}
+		val, err = newValidator(*validate, *tol)
+		if err != nil {
+			log.Fatalf("could not create validator: %+v", err)
+		}
+	}
+
+	// Checkpointed conversion, written in ROOT chunks
+	var (
+		base    = strings.TrimSuffix(*ifname, filepath.Ext(*ifname))
+		ckname  = base + ".ckpt.json"
+		chunked = *nckpt > 0 || *resume
+		ck      = &checkpoint{Input: *ifname}
+	)
+	if chunked && *format != "root" {
+		log.Fatalf("checkpoints are only available for the ROOT output format")
+	}
+	if *resume {
+		ck, err = readCheckpoint(ckname)
+		if err != nil {
+			log.Fatalf("could not read checkpoint: %+v", err)
+		}
+		if ck.Input != *ifname {
+			log.Fatalf("checkpoint %q was written for %q, not %q", ckname, ck.Input, *ifname)
+		}
+		fmt.Println(" --> Resuming after", ck.Events, "events, from offset", ck.Offset)
+	}
+
+	// Get the event source. LHE files are read from the checkpoint
+	// offset, while HepMC events already processed are skipped.
+	var (
+		src    source
+		in     *countingReader
+		lhedec *lhef.Decoder
+	)
+	if isHepMC {
+		in = newCountingReader(f, 0)
+		src, err = newHepMCSource(in, m, lay, *status, *verbose)
+		if err != nil {
+			log.Fatalf("could not create HepMC source: %+v", err)
+		}
+		for i := 0; i < ck.Events; i++ {
+			_, err = src.next()
+			if err != nil {
+				log.Fatalf("could not skip event %d: %+v", i, err)
 			}
-			log.Fatalf("could not decode event %d: %+v", iEvt, err)
 		}
+	} else {
+		lhedec, in, err = newLHEInput(f, ck.Offset)
+		if err != nil {
+			log.Fatalf("could not create LHE decoder: %+v", err)
+		}
+		src = &lheSource{dec: lhedec, m: m, lay: lay, val: val, verbose: *verbose}
+	}
+	if val != nil {
+		val.nEvt, val.nBad = ck.Events, ck.Bad
+		for b, n := range ck.Checks {
+			val.counts[b] = n
+		}
+	}
 
-		// Print the event in verbose mode
-		if *verbose {
-			fmt.Println()
-			fmt.Println(*lheEvt)
+	// Output event and selection
+	var (
+		e     = newEvent(m)
+		wvars = setBranches(&e, m, lay)
+		sel   *selection
+	)
+	if val != nil && !val.drop {
+		wvars = append(wvars, rtree.WriteVar{Name: "lhe_bad", Value: &e.bad})
+	}
+	if *cut != "" {
+		sel, err = newSelection(*cut, wvars, &e, m)
+		if err != nil {
+			log.Fatalf("could not create selection: %+v", err)
 		}
+	}
 
-		// Event weight
-		e.w = lheEvt.XWGTUP
-		
-		// Converting the information from LHE event to TTree event
-		var (
-			pids     = lheEvt.IDUP
-			PxPyPzEM = lheEvt.PUP
-			setPart  = func(part *Particle, P fmom.PxPyPzE, pid int64) {
-				part.pt = float32(P.Pt())
-				part.eta = float32(P.Eta())
-				part.phi = float32(P.Phi())
-				part.m = float32(P.M())
-				part.pid = int32(pid)
+	// Prepare the output files, one per requested format
+	var (
+		outs    []eventWriter
+		ofnames []string
+		chunks  *chunkWriter
+	)
+	for _, fmtName := range strings.Split(*format, ",") {
+		ofname := base + "." + fmtName
+		switch {
+		case chunked:
+			chunks = &chunkWriter{ofname: ofname, tname: *tname, wvars: wvars, parts: ck.Parts}
+			if lhedec != nil {
+				chunks.run = &lhedec.Run
 			}
-		)
-		
-		// Loop over particles
-		for i, pid := range pids {
-
-			// Incoming particle 1 & 2
-			if i == 0 {
-				p := get4Vec(PxPyPzEM[i])
-				e.i1pz = p.Pz()
-				e.i1id = int32(pid)
-				e.i1h  = lheEvt.SPINUP[i]
+			outs = append(outs, chunks)
+		case fmtName == "root":
+			fout, err := groot.Create(ofname)
+			if err != nil {
+				log.Fatalf("could not create ROOT file %q: %+v", ofname, err)
 			}
-			if i == 1 {
-				p := get4Vec(PxPyPzEM[i])
-				e.i2pz = p.Pz()
-				e.i2id = int32(pid)
-				e.i2h  = lheEvt.SPINUP[i]
+			defer fout.Close()
+			tw, err := rtree.NewWriter(fout, *tname, wvars)
+			if err != nil {
+				log.Fatalf("could not create scanner: %+v", err)
 			}
+			defer tw.Close()
 
-			// The rest of particles
-			switch int(pid) {
-			case heppdt.PDG_t:
-				setPart(&e.t, get4Vec(PxPyPzEM[i]), pid)
-			case heppdt.PDG_anti_t:
-				setPart(&e.tbar, get4Vec(PxPyPzEM[i]), pid)
-			case heppdt.PDG_b:
-				setPart(&e.b, get4Vec(PxPyPzEM[i]), pid)
-			case heppdt.PDG_anti_b:
-				setPart(&e.bbar, get4Vec(PxPyPzEM[i]), pid)
-			case heppdt.PDG_W_plus:
-				setPart(&e.W, get4Vec(PxPyPzEM[i]), pid)
-			case heppdt.PDG_W_minus:
-				setPart(&e.Wbar, get4Vec(PxPyPzEM[i]), pid)
-			case heppdt.PDG_e_plus, heppdt.PDG_mu_plus, heppdt.PDG_tau_plus:
-				setPart(&e.lbar, get4Vec(PxPyPzEM[i]), pid)
-			case heppdt.PDG_e_minus, heppdt.PDG_mu_minus, heppdt.PDG_tau_minus:
-				setPart(&e.l, get4Vec(PxPyPzEM[i]), pid)
-			case heppdt.PDG_nu_e, heppdt.PDG_nu_mu, heppdt.PDG_nu_tau:
-				setPart(&e.v, get4Vec(PxPyPzEM[i]), pid)
-			case heppdt.PDG_anti_nu_e, heppdt.PDG_anti_nu_mu, heppdt.PDG_anti_nu_tau:
-				setPart(&e.vbar, get4Vec(PxPyPzEM[i]), pid)
+			// Save the LHE init block
+			if lhedec != nil {
+				writeRun(fout, *tname+"_init", lhedec.Run)
 			}
+			outs = append(outs, &rootWriter{f: fout, tw: tw})
+		case fmtName == "parquet" || fmtName == "arrow":
+			w, err := newArrowWriter(ofname, wvars, fmtName == "parquet")
+			if err != nil {
+				log.Fatalf("could not create %s file %q: %+v", fmtName, ofname, err)
+			}
+			outs = append(outs, w)
+		case fmtName == "csv":
+			w, err := newCSVWriter(ofname, wvars)
+			if err != nil {
+				log.Fatalf("could not create CSV file %q: %+v", ofname, err)
+			}
+			outs = append(outs, w)
+		default:
+			log.Fatalf("unknown output format %q (expected root, parquet, arrow or csv)", fmtName)
+		}
+		ofnames = append(ofnames, ofname)
+	}
+
+	// Event loop: decoding, conversion and writing are pipelined
+	var (
+		start      = time.Now()
+		nEvt0      = ck.Events
+		nEvt       = nEvt0
+		nPass      = ck.Passed
+		sumw, sumP = ck.SumW, ck.SumPass
+		size       int64
+	)
+	if fi, err := f.Stat(); err == nil {
+		size = fi.Size()
+	}
+	prog := newProgress(*every, size, nEvt, in.n)
+	_, err = runPipeline(src, *nworkers, in, func(evt Event, off int64) error {
+		nEvt++
+		defer prog.update(nEvt, off)
+		e.assign(evt)
+		sumw += e.w
+		if val != nil {
+			val.count(e.bad)
+		}
+		if (val == nil || !val.drop || e.bad == 0) && (sel == nil || sel.pass()) {
+			nPass++
+			sumP += e.w
+			for _, w := range outs {
+				err := w.write()
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// Close the current chunk and record where to resume
+		if chunks == nil || *nckpt <= 0 || nEvt%*nckpt != 0 {
+			return nil
+		}
+		err := chunks.flush()
+		if err != nil {
+			return err
+		}
+		ck.Events, ck.Offset, ck.Parts = nEvt, off, chunks.parts
+		ck.Passed, ck.SumW, ck.SumPass = nPass, sumw, sumP
+		if val != nil {
+			ck.Bad, ck.Checks = val.nBad, val.counts
 		}
+		return ck.write(ckname)
+	})
+	if err != nil {
+		log.Fatalf("could not convert events: %+v", err)
+	}
+	elapsed := time.Since(start)
 
-		// Write the TTree
-		_, err = tw.Write()
+	for i, w := range outs {
+		err = w.close()
 		if err != nil {
-			log.Fatalf("could not write event %d: %+v", iEvt, err)
+			log.Fatalf("could not close output file %q: %+v", ofnames[i], err)
 		}
-		iEvt++
+	}
+	if chunks != nil {
+		os.Remove(ckname)
 	}
 
-	err = tw.Close()
+	if val != nil {
+		val.report()
+	}
+	fmt.Println(" --> Event loop is done:", nEvt, "events processed and", nPass, "stored in", strings.Join(ofnames, ", "))
+	if sel != nil {
+		fmt.Printf(" --> Selection %q: %d/%d events passed, sum of weights %g/%g\n",
+			sel.expr, nPass, nEvt, sumP, sumw)
+	}
+	fmt.Printf(" --> Throughput: %.0f events/s (%d workers, %v)\n",
+		float64(nEvt-nEvt0)/elapsed.Seconds(), *nworkers, elapsed.Round(time.Millisecond))
+}
+
+// Convert a LHE event into a TTree event
+func convertEvent(lheEvt *lhef.HEPEUP, m *Mapping) (Event, error) {
+	e := newEvent(m)
+
+	// Event weight
+	e.w = lheEvt.XWGTUP
+
+	// Raw LHE record
+	err := setRecord(&e.lhe, lheEvt)
 	if err != nil {
-		log.Fatalf("could not close tree-writer: %+v", err)
+		return e, err
 	}
 
-	fmt.Println(" --> Event loop is done:", iEvt, "events processed and stored in", ofname)
+	// Converting the information from LHE event to TTree event
+	var (
+		pids     = lheEvt.IDUP
+		PxPyPzEM = lheEvt.PUP
+		cands    = make([]candidate, 0, len(pids))
+		parents  = func(i int) []int {
+			var ps []int
+			m1, m2 := int(lheEvt.MOTHUP[i][0]), int(lheEvt.MOTHUP[i][1])
+			if m2 < m1 {
+				m2 = m1
+			}
+			for j := m1; j <= m2; j++ {
+				if j >= 1 && j <= len(pids) {
+					ps = append(ps, j-1)
+				}
+			}
+			return ps
+		}
+		pidOf   = func(i int) int64 { return pids[i] }
+	)
+
+	// Loop over particles
+	for i, pid := range pids {
+
+		// Incoming particle 1 & 2
+		if i == 0 {
+			p := PxPyPzEM[i]
+			e.i1p = [4]float64{p[0], p[1], p[2], p[3]}
+			e.i1id = int32(pid)
+			e.i1h = lheEvt.SPINUP[i]
+		}
+		if i == 1 {
+			p := PxPyPzEM[i]
+			e.i2p = [4]float64{p[0], p[1], p[2], p[3]}
+			e.i2id = int32(pid)
+			e.i2h = lheEvt.SPINUP[i]
+		}
+
+		// The rest of particles
+		i := i
+		cands = append(cands, candidate{
+			pid:       pid,
+			status:    int(lheEvt.ISTUP[i]),
+			p4:        get4Vec(PxPyPzEM[i]),
+			ancestors: func() []int64 { return ancestorIDs(i, parents, pidOf) },
+		})
+	}
+	m.fill(&e, cands, lheStatus)
+
+	return e, nil
 }
 
-func setBranches(e *Event) []rtree.WriteVar {
-	return []rtree.WriteVar{
+// Create an empty event with the particles of the mapping
+func newEvent(m *Mapping) Event {
+	return Event{parts: make([]Particle, len(m.Slots))}
+}
+
+// Copy the content of an event, keeping the particles storage
+// of e (whose addresses are bound to the tree branches)
+func (e *Event) assign(o Event) {
+	parts := e.parts
+	*e = o
+	e.parts = parts
+	copy(e.parts, o.parts)
+}
+
+// Fill a particle from its four-vector
+func setParticle(part *Particle, P fmom.PxPyPzE, pid int64) {
+	part.p4 = P
+	part.pid = int32(pid)
+}
+
+// Get the (px, py, pz, E) components of a four-vector
+func p4Array(P fmom.PxPyPzE) [4]float64 {
+	return [4]float64{P.Px(), P.Py(), P.Pz(), P.E()}
+}
+
+// Compute the stored components of all the particles
+func (e *Event) project(l Layout) {
+	for i := range e.parts {
+		e.parts[i].project(l)
+	}
+}
+
+func setBranches(e *Event, m *Mapping, l Layout) []rtree.WriteVar {
+	wvars := []rtree.WriteVar{
 
 		// Weight
 		{Name: "w_xec", Value: &e.w},
 		
-		// Incoming particles
-		{Name: "init1_pz", Value: &e.i1pz},
+		// Incoming particles, always as (px, py, pz, E)
+		// since eta is not defined along the beam
+		{Name: "init1_px", Value: &e.i1p[0]},
+		{Name: "init1_py", Value: &e.i1p[1]},
+		{Name: "init1_pz", Value: &e.i1p[2]},
+		{Name: "init1_e", Value: &e.i1p[3]},
 		{Name: "init1_id", Value: &e.i1id},
 		{Name: "init1_he", Value: &e.i1h},
-		{Name: "init2_pz", Value: &e.i2pz},
+		{Name: "init2_px", Value: &e.i2p[0]},
+		{Name: "init2_py", Value: &e.i2p[1]},
+		{Name: "init2_pz", Value: &e.i2p[2]},
+		{Name: "init2_e", Value: &e.i2p[3]},
 		{Name: "init2_id", Value: &e.i2id},
 		{Name: "init2_he", Value: &e.i2h},
-		
-		// Top
-		{Name: "t_pt", Value: &e.t.pt},
-		{Name: "t_eta", Value: &e.t.eta},
-		{Name: "t_phi", Value: &e.t.phi},
-		{Name: "t_pid", Value: &e.t.pid},
-		{Name: "t_m", Value: &e.t.m},
-		{Name: "tbar_pt", Value: &e.tbar.pt},
-		{Name: "tbar_eta", Value: &e.tbar.eta},
-		{Name: "tbar_phi", Value: &e.tbar.phi},
-		{Name: "tbar_pid", Value: &e.tbar.pid},
-		{Name: "tbar_m", Value: &e.tbar.m},
-
-		// b-quarks
-		{Name: "b_pt", Value: &e.b.pt},
-		{Name: "b_eta", Value: &e.b.eta},
-		{Name: "b_phi", Value: &e.b.phi},
-		{Name: "b_pid", Value: &e.b.pid},
-		{Name: "b_m", Value: &e.b.m},
-		{Name: "bbar_pt", Value: &e.bbar.pt},
-		{Name: "bbar_eta", Value: &e.bbar.eta},
-		{Name: "bbar_phi", Value: &e.bbar.phi},
-		{Name: "bbar_pid", Value: &e.bbar.pid},
-		{Name: "bbar_m", Value: &e.bbar.m},
-
-		// W-boson
-		{Name: "W_pt", Value: &e.W.pt},
-		{Name: "W_eta", Value: &e.W.eta},
-		{Name: "W_phi", Value: &e.W.phi},
-		{Name: "W_pid", Value: &e.W.pid},
-		{Name: "W_m", Value: &e.W.m},
-		{Name: "Wbar_pt", Value: &e.Wbar.pt},
-		{Name: "Wbar_eta", Value: &e.Wbar.eta},
-		{Name: "Wbar_phi", Value: &e.Wbar.phi},
-		{Name: "Wbar_pid", Value: &e.Wbar.pid},
-		{Name: "Wbar_m", Value: &e.Wbar.m},
-
-		// Charged leptons
-		{Name: "l_pt", Value: &e.l.pt},
-		{Name: "l_eta", Value: &e.l.eta},
-		{Name: "l_phi", Value: &e.l.phi},
-		{Name: "l_pid", Value: &e.l.pid},
-		{Name: "l_m", Value: &e.l.m},
-		{Name: "lbar_pt", Value: &e.lbar.pt},
-		{Name: "lbar_eta", Value: &e.lbar.eta},
-		{Name: "lbar_phi", Value: &e.lbar.phi},
-		{Name: "lbar_pid", Value: &e.lbar.pid},
-		{Name: "lbar_m", Value: &e.lbar.m},
-
-		// Neutrinos
-		{Name: "v_pt", Value: &e.v.pt},
-		{Name: "v_eta", Value: &e.v.eta},
-		{Name: "v_phi", Value: &e.v.phi},
-		{Name: "v_pid", Value: &e.v.pid},
-		{Name: "v_m", Value: &e.v.m},
-		{Name: "vbar_pt", Value: &e.vbar.pt},
-		{Name: "vbar_eta", Value: &e.vbar.eta},
-		{Name: "vbar_phi", Value: &e.vbar.phi},
-		{Name: "vbar_pid", Value: &e.vbar.pid},
-		{Name: "vbar_m", Value: &e.vbar.m},
 	}
 
+	// Final state particles, the pid being stored after the
+	// third component as in the original ttbar tree layout
+	names := l.names()
+	for i, s := range m.Slots {
+		p := &e.parts[i]
+		for j, c := range names {
+			var v interface{} = &p.c32[j]
+			if l.F64 {
+				v = &p.c[j]
+			}
+			wvars = append(wvars, rtree.WriteVar{Name: s.Name + "_" + c, Value: v})
+			if j == 2 {
+				wvars = append(wvars, rtree.WriteVar{Name: s.Name + "_pid", Value: &p.pid})
+			}
+		}
+	}
+
+	return append(wvars, []rtree.WriteVar{
+		// Raw LHE record
+		{Name: "lhe_nup", Value: &e.lhe.nup},
+		{Name: "lhe_idprup", Value: &e.lhe.idprup},
+		{Name: "lhe_scalup", Value: &e.lhe.scalup},
+		{Name: "lhe_aqedup", Value: &e.lhe.aqedup},
+		{Name: "lhe_aqcdup", Value: &e.lhe.aqcdup},
+		{Name: "lhe_idup", Value: &e.lhe.idup, Count: "lhe_nup"},
+		{Name: "lhe_istup", Value: &e.lhe.istup, Count: "lhe_nup"},
+		{Name: "lhe_moth1", Value: &e.lhe.moth1, Count: "lhe_nup"},
+		{Name: "lhe_moth2", Value: &e.lhe.moth2, Count: "lhe_nup"},
+		{Name: "lhe_icol1", Value: &e.lhe.icol1, Count: "lhe_nup"},
+		{Name: "lhe_icol2", Value: &e.lhe.icol2, Count: "lhe_nup"},
+		{Name: "lhe_px", Value: &e.lhe.px, Count: "lhe_nup"},
+		{Name: "lhe_py", Value: &e.lhe.py, Count: "lhe_nup"},
+		{Name: "lhe_pz", Value: &e.lhe.pz, Count: "lhe_nup"},
+		{Name: "lhe_e", Value: &e.lhe.e, Count: "lhe_nup"},
+		{Name: "lhe_m", Value: &e.lhe.m, Count: "lhe_nup"},
+		{Name: "lhe_vtimup", Value: &e.lhe.vtimup, Count: "lhe_nup"},
+		{Name: "lhe_spinup", Value: &e.lhe.spinup, Count: "lhe_nup"},
+	}...)
+}
+
+func setRunBranches(r *Run) []rtree.WriteVar {
+	return []rtree.WriteVar{
+		{Name: "idbmup", Value: &r.idbmup},
+		{Name: "ebmup", Value: &r.ebmup},
+		{Name: "pdfgup", Value: &r.pdfgup},
+		{Name: "pdfsup", Value: &r.pdfsup},
+		{Name: "idwtup", Value: &r.idwtup},
+		{Name: "nprup", Value: &r.nprup},
+		{Name: "xsecup", Value: &r.xsecup, Count: "nprup"},
+		{Name: "xerrup", Value: &r.xerrup, Count: "nprup"},
+		{Name: "xmaxup", Value: &r.xmaxup, Count: "nprup"},
+		{Name: "lprup", Value: &r.lprup, Count: "nprup"},
+	}
+}
+
+// Copy the HEPEUP block into the raw record
+func setRecord(r *Record, evt *lhef.HEPEUP) error {
+	err := checkLengths(evt)
+	if err != nil {
+		return err
+	}
+
+	n := int(evt.NUP)
+	r.nup = evt.NUP
+	r.idprup = evt.IDPRUP
+	r.scalup = evt.SCALUP
+	r.aqedup = evt.AQEDUP
+	r.aqcdup = evt.AQCDUP
+	r.idup = r.idup[:0]
+	r.istup = r.istup[:0]
+	r.moth1, r.moth2 = r.moth1[:0], r.moth2[:0]
+	r.icol1, r.icol2 = r.icol1[:0], r.icol2[:0]
+	r.px, r.py, r.pz = r.px[:0], r.py[:0], r.pz[:0]
+	r.e, r.m = r.e[:0], r.m[:0]
+	r.vtimup = r.vtimup[:0]
+	r.spinup = r.spinup[:0]
+	for i := 0; i < n; i++ {
+		r.idup = append(r.idup, int32(evt.IDUP[i]))
+		r.istup = append(r.istup, evt.ISTUP[i])
+		r.moth1 = append(r.moth1, evt.MOTHUP[i][0])
+		r.moth2 = append(r.moth2, evt.MOTHUP[i][1])
+		r.icol1 = append(r.icol1, evt.ICOLUP[i][0])
+		r.icol2 = append(r.icol2, evt.ICOLUP[i][1])
+		r.px = append(r.px, evt.PUP[i][0])
+		r.py = append(r.py, evt.PUP[i][1])
+		r.pz = append(r.pz, evt.PUP[i][2])
+		r.e = append(r.e, evt.PUP[i][3])
+		r.m = append(r.m, evt.PUP[i][4])
+		r.vtimup = append(r.vtimup, evt.VTIMUP[i])
+		r.spinup = append(r.spinup, evt.SPINUP[i])
+	}
+	return nil
+}
+
+// Write the HEPRUP init block as a single-entry TTree
+func writeRun(dir riofs.Directory, tname string, run lhef.HEPRUP) {
+	var r Run
+	for i := 0; i < 2; i++ {
+		r.idbmup[i] = int32(run.IDBMUP[i])
+		r.ebmup[i] = run.EBMUP[i]
+		r.pdfgup[i] = run.PDFGUP[i]
+		r.pdfsup[i] = run.PDFSUP[i]
+	}
+	r.idwtup = run.IDWTUP
+	r.nprup = run.NPRUP
+	r.xsecup = run.XSECUP
+	r.xerrup = run.XERRUP
+	r.xmaxup = run.XMAXUP
+	r.lprup = run.LPRUP
+
+	tw, err := rtree.NewWriter(dir, tname, setRunBranches(&r))
+	if err != nil {
+		log.Fatalf("could not create init tree-writer: %+v", err)
+	}
+	_, err = tw.Write()
+	if err != nil {
+		log.Fatalf("could not write init block: %+v", err)
+	}
+	err = tw.Close()
+	if err != nil {
+		log.Fatalf("could not close init tree-writer: %+v", err)
+	}
 }
 
 func get4Vec(x [5]float64) fmom.PxPyPzE {
@@ -0,0 +1,183 @@
+// Configurable mapping between generator particles and tree branches
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"go-hep.org/x/hep/fmom"
+	"go-hep.org/x/hep/heppdt"
+)
+
+// Slot describes a named particle of the output tree, stored in
+// the <name>_pt, <name>_eta, <name>_phi, <name>_pid, <name>_m branches.
+// A particle fills the slot if its PDG ID is listed, if its status is
+// listed (any status if empty) and if one of its ancestors has one of
+// the Ancestor PDG IDs (no requirement if empty).
+type Slot struct {
+	Name     string  `json:"name"`
+	PDG      []int64 `json:"pdg"`
+	Status   []int   `json:"status,omitempty"`
+	Ancestor []int64 `json:"ancestor,omitempty"`
+}
+
+// Mapping is the ordered list of slots. Each particle fills the first
+// matching slot still empty.
+type Mapping struct {
+	Slots []Slot `json:"slots"`
+}
+
+// Default mapping, for the partonic ttbar->dilepton final state
+var defaultMapping = Mapping{
+	Slots: []Slot{
+		{Name: "t", PDG: []int64{heppdt.PDG_t}},
+		{Name: "tbar", PDG: []int64{heppdt.PDG_anti_t}},
+		{Name: "b", PDG: []int64{heppdt.PDG_b}},
+		{Name: "bbar", PDG: []int64{heppdt.PDG_anti_b}},
+		{Name: "W", PDG: []int64{heppdt.PDG_W_plus}},
+		{Name: "Wbar", PDG: []int64{heppdt.PDG_W_minus}},
+		{Name: "l", PDG: []int64{heppdt.PDG_e_minus, heppdt.PDG_mu_minus, heppdt.PDG_tau_minus}},
+		{Name: "lbar", PDG: []int64{heppdt.PDG_e_plus, heppdt.PDG_mu_plus, heppdt.PDG_tau_plus}},
+		{Name: "v", PDG: []int64{heppdt.PDG_nu_e, heppdt.PDG_nu_mu, heppdt.PDG_nu_tau}},
+		{Name: "vbar", PDG: []int64{heppdt.PDG_anti_nu_e, heppdt.PDG_anti_nu_mu, heppdt.PDG_anti_nu_tau}},
+	},
+}
+
+// Status codes of the LHE particles filling the slots without status
+// requirement: the outgoing and intermediate particles, so that the
+// incoming partons (e.g. the b quarks of b bbar -> t tbar) are skipped
+var lheStatus = map[int]bool{1: true, 2: true}
+
+// Read a mapping from a JSON file
+func readMapping(fname string) (*Mapping, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m Mapping
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&m)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode mapping %q: %w", fname, err)
+	}
+
+	names := make(map[string]bool)
+	for _, s := range m.Slots {
+		switch {
+		case s.Name == "":
+			return nil, fmt.Errorf("mapping %q: slot without name", fname)
+		case names[s.Name]:
+			return nil, fmt.Errorf("mapping %q: duplicate slot %q", fname, s.Name)
+		case len(s.PDG) == 0:
+			return nil, fmt.Errorf("mapping %q: slot %q without PDG ID", fname, s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	return &m, nil
+}
+
+// Particle candidate to fill the slots
+type candidate struct {
+	pid       int64
+	status    int
+	p4        fmom.PxPyPzE
+	ancestors func() []int64 // PDG IDs of all the ancestors, computed on demand
+}
+
+// Fill the event particles with the candidates, in their order.
+// With defStatus non-nil, slots without status requirement only
+// accept candidates with these status codes.
+func (m *Mapping) fill(e *Event, cands []candidate, defStatus map[int]bool) {
+	for _, c := range cands {
+		for i := range m.Slots {
+			if e.parts[i].pid != 0 || !m.Slots[i].match(c, defStatus) {
+				continue
+			}
+			setParticle(&e.parts[i], c.p4, c.pid)
+			break
+		}
+	}
+}
+
+// Can a particle with this PDG ID fill any slot?
+func (m *Mapping) wants(pid int64) bool {
+	for i := range m.Slots {
+		if containsInt64(m.Slots[i].PDG, pid) {
+			return true
+		}
+	}
+	return false
+}
+
+// Does the candidate satisfy the slot requirements?
+func (s *Slot) match(c candidate, defStatus map[int]bool) bool {
+	if !containsInt64(s.PDG, c.pid) {
+		return false
+	}
+
+	switch {
+	case len(s.Status) > 0:
+		ok := false
+		for _, st := range s.Status {
+			ok = ok || st == c.status
+		}
+		if !ok {
+			return false
+		}
+	case defStatus != nil && !defStatus[c.status]:
+		return false
+	}
+
+	if len(s.Ancestor) == 0 {
+		return true
+	}
+	for _, pid := range c.ancestors() {
+		if containsInt64(s.Ancestor, pid) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort candidates by decreasing transverse momentum
+func sortByPt(cands []candidate) {
+	sort.SliceStable(cands, func(i, j int) bool {
+		return cands[i].p4.Pt() > cands[j].p4.Pt()
+	})
+}
+
+func containsInt64(s []int64, v int64) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk up a particle record given the parents of each particle,
+// and return the PDG IDs of all the ancestors of particle i
+func ancestorIDs(i int, parents func(i int) []int, pid func(i int) int64) []int64 {
+	var (
+		ids   []int64
+		seen  = map[int]bool{i: true}
+		queue = parents(i)
+	)
+	for len(queue) > 0 {
+		j := queue[0]
+		queue = queue[1:]
+		if seen[j] {
+			continue
+		}
+		seen[j] = true
+		ids = append(ids, pid(j))
+		queue = append(queue, parents(j)...)
+	}
+	return ids
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"go-hep.org/x/hep/lhef"
+)
+
+func TestMappingIncomingPartons(t *testing.T) {
+	// b bbar -> t tbar -> (W+ b) (W- bbar), with the leptonic W decays
+	evt := &lhef.HEPEUP{
+		NUP:    12,
+		XWGTUP: 1,
+		IDUP:   []int64{5, -5, 6, -6, 24, 5, -24, -5, -11, 12, 13, -14},
+		ISTUP:  []int32{-1, -1, 2, 2, 2, 1, 2, 1, 1, 1, 1, 1},
+		MOTHUP: [][2]int32{{0, 0}, {0, 0}, {1, 2}, {1, 2}, {3, 0}, {3, 0}, {4, 0}, {4, 0}, {5, 0}, {5, 0}, {7, 0}, {7, 0}},
+		ICOLUP: make([][2]int32, 12),
+		PUP:    make([][5]float64, 12),
+		VTIMUP: make([]float64, 12),
+		SPINUP: make([]float64, 12),
+	}
+	for i := range evt.PUP {
+		// Distinct transverse momenta to identify the particles
+		evt.PUP[i] = [5]float64{float64(i + 1), 0, 10, 100, 0}
+	}
+	evt.PUP[0] = [5]float64{0, 0, 500, 500, 0}
+	evt.PUP[1] = [5]float64{0, 0, -500, 500, 0}
+
+	e, err := convertEvent(evt, &defaultMapping)
+	if err != nil {
+		t.Fatalf("could not convert event: %+v", err)
+	}
+	for i, s := range defaultMapping.Slots {
+		var want int
+		switch s.Name {
+		case "t":
+			want = 2
+		case "tbar":
+			want = 3
+		case "W":
+			want = 4
+		case "b":
+			want = 5
+		case "Wbar":
+			want = 6
+		case "bbar":
+			want = 7
+		case "lbar":
+			want = 8
+		case "v":
+			want = 9
+		case "l":
+			want = 10
+		case "vbar":
+			want = 11
+		default:
+			t.Fatalf("unexpected slot %q", s.Name)
+		}
+		p := e.parts[i]
+		if int64(p.pid) != evt.IDUP[want] || p.p4.Px() != evt.PUP[want][0] {
+			t.Errorf("slot %q: got particle (pid=%d, px=%g), want particle %d (pid=%d, px=%g)",
+				s.Name, p.pid, p.p4.Px(), want+1, evt.IDUP[want], evt.PUP[want][0])
+		}
+	}
+}
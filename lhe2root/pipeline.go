@@ -0,0 +1,171 @@
+// Concurrent decoding, conversion and writing of generator events
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"go-hep.org/x/hep/lhef"
+)
+
+// source decodes generator events from a file and converts them into
+// TTree events. Decoding is sequential, conversion may run concurrently.
+type source interface {
+	// next returns the next decoded event, or io.EOF at the end of the file
+	next() (interface{}, error)
+
+	// convert turns a decoded event into a TTree event, with the stored
+	// components of the four-vectors
+	convert(raw interface{}) (Event, error)
+}
+
+// LHE event source
+type lheSource struct {
+	dec     *lhef.Decoder
+	m       *Mapping
+	lay     Layout
+	val     *validator
+	verbose bool
+}
+
+func (s *lheSource) next() (interface{}, error) {
+	lheEvt, err := s.dec.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if s.verbose {
+		fmt.Println()
+		fmt.Println(*lheEvt)
+	}
+	return lheEvt, nil
+}
+
+func (s *lheSource) convert(raw interface{}) (Event, error) {
+	lheEvt := raw.(*lhef.HEPEUP)
+
+	// Events with inconsistent particle arrays cannot be converted:
+	// they are only kept, with their weight, to be flagged or dropped
+	if s.val != nil && checkLengths(lheEvt) != nil {
+		e := newEvent(s.m)
+		e.w = lheEvt.XWGTUP
+		e.bad = badLength
+		return e, nil
+	}
+
+	e, err := convertEvent(lheEvt, s.m)
+	if err != nil {
+		return e, err
+	}
+	e.project(s.lay)
+	if s.val != nil {
+		e.bad = s.val.check(&e.lhe)
+	}
+	return e, nil
+}
+
+// Event travelling through the pipeline, tagged with its position in the file
+// and with the input offset after it
+type job struct {
+	ievt int
+	off  int64
+	raw  interface{}
+	evt  Event
+	err  error
+}
+
+// runPipeline decodes the events in one goroutine, converts them with
+// nworkers goroutines and hands them to write in the original file order,
+// together with the input offset given by in after decoding the event.
+// It returns the number of written events.
+func runPipeline(src source, nworkers int, in *countingReader, write func(Event, int64) error) (int, error) {
+
+	if nworkers < 1 {
+		nworkers = 1
+	}
+
+	var (
+		jobs    = make(chan job, 4*nworkers)
+		results = make(chan job, 4*nworkers)
+		done    = make(chan struct{}) // closed on the first error
+		decErr  error
+	)
+
+	// Decoder: read events sequentially from the file, until the
+	// end of the file or the first error
+	go func() {
+		defer close(jobs)
+		for i := 0; ; i++ {
+			raw, err := src.next()
+			if err != nil {
+				if err != io.EOF {
+					decErr = fmt.Errorf("could not decode event %d: %w", i, err)
+				}
+				return
+			}
+			select {
+			case jobs <- job{ievt: i, off: in.n, raw: raw}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Converters: turn LHE events into TTree events
+	var wg sync.WaitGroup
+	wg.Add(nworkers)
+	for w := 0; w < nworkers; w++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.evt, j.err = src.convert(j.raw)
+				j.raw = nil
+				results <- j
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Writer: restore the file order before writing. In case of error,
+	// the decoder is stopped and the events already decoded are drained
+	// without writing anything more.
+	var (
+		pending = make(map[int]job)
+		next    = 0
+		err     error
+	)
+	for j := range results {
+		if err != nil {
+			continue
+		}
+		pending[j.ievt] = j
+		for {
+			j, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if j.err != nil {
+				err = fmt.Errorf("could not convert event %d: %w", next, j.err)
+				break
+			}
+			err = write(j.evt, j.off)
+			if err != nil {
+				err = fmt.Errorf("could not write event %d: %w", next, err)
+				break
+			}
+			next++
+		}
+		if err != nil {
+			close(done)
+		}
+	}
+	if err != nil {
+		return next, err
+	}
+
+	return next, decErr
+}
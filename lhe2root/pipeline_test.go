@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-hep.org/x/hep/groot"
+	"go-hep.org/x/hep/groot/rtree"
+	"go-hep.org/x/hep/lhef"
+)
+
+// Write a LHE file with n events, copies of the events of testdata/ttbar.lhe
+// with distinct weights
+func writeBigLHE(t *testing.T, fname string, n int) {
+	t.Helper()
+
+	run, evts := readLHE(t, filepath.Join("testdata", "ttbar.lhe"))
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatalf("could not create LHE file: %+v", err)
+	}
+	enc, err := lhef.NewEncoder(f)
+	if err != nil {
+		t.Fatalf("could not create LHE encoder: %+v", err)
+	}
+	enc.Run = run
+	for i := 0; i < n; i++ {
+		evt := evts[i%len(evts)]
+		evt.XWGTUP = float64(i + 1)
+		err = enc.Encode(&evt)
+		if err != nil {
+			t.Fatalf("could not encode event %d: %+v", i, err)
+		}
+	}
+	err = enc.Close()
+	if err != nil {
+		t.Fatalf("could not close LHE file: %+v", err)
+	}
+}
+
+func TestPipelineWorkers(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		ifname = filepath.Join(dir, "big.lhe")
+		outs   []string
+	)
+	writeBigLHE(t, ifname, 500)
+	for _, nworkers := range []int{1, 8} {
+		rname := filepath.Join(dir, fmt.Sprintf("big_j%d.root", nworkers))
+		lhe2root(t, ifname, rname, "truth", nworkers)
+		outs = append(outs, dumpTree(t, rname, "truth"))
+	}
+	if outs[0] != outs[1] {
+		t.Fatalf("outputs of 1 and 8 workers differ")
+	}
+}
+
+// Text dump of all the branches of all the entries of a tree
+func dumpTree(t *testing.T, fname, tname string) string {
+	t.Helper()
+
+	f, err := groot.Open(fname)
+	if err != nil {
+		t.Fatalf("could not open ROOT file: %+v", err)
+	}
+	defer f.Close()
+	obj, err := f.Get(tname)
+	if err != nil {
+		t.Fatalf("could not retrieve tree: %+v", err)
+	}
+	var (
+		tree  = obj.(rtree.Tree)
+		rvars = rtree.NewReadVars(tree)
+		sb    strings.Builder
+	)
+	r, err := rtree.NewReader(tree, rvars)
+	if err != nil {
+		t.Fatalf("could not create tree reader: %+v", err)
+	}
+	defer r.Close()
+	err = r.Read(func(ctx rtree.RCtx) error {
+		for _, rv := range rvars {
+			fmt.Fprintf(&sb, "%d %s %v\n", ctx.Entry, rv.Name, reflect.ValueOf(rv.Value).Elem().Interface())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("could not read tree: %+v", err)
+	}
+	if tree.Entries() == 0 {
+		t.Fatalf("empty tree in %q", fname)
+	}
+	return sb.String()
+}
+
+// Source of n empty events, the conversion of the event bad failing
+type failingSource struct {
+	n, bad  int
+	decoded int
+}
+
+func (s *failingSource) next() (interface{}, error) {
+	if s.decoded == s.n {
+		return nil, io.EOF
+	}
+	s.decoded++
+	return s.decoded - 1, nil
+}
+
+func (s *failingSource) convert(raw interface{}) (Event, error) {
+	if raw.(int) == s.bad {
+		return Event{}, errors.New("bad event")
+	}
+	return Event{w: float64(raw.(int))}, nil
+}
+
+func TestPipelineStopsOnError(t *testing.T) {
+	src := &failingSource{n: 100000, bad: 10}
+	nw, err := runPipeline(src, 4, newCountingReader(nil, 0), func(e Event, off int64) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected a conversion error")
+	}
+	if nw != src.bad {
+		t.Fatalf("invalid number of written events: got %d, want %d", nw, src.bad)
+	}
+	if src.decoded == src.n {
+		t.Fatalf("all the events were decoded despite the error")
+	}
+}
@@ -0,0 +1,230 @@
+// Convert a ROOT TTree produced by lhe2root back into a LHE file
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-hep.org/x/hep/groot"
+	"go-hep.org/x/hep/groot/rtree"
+	"go-hep.org/x/hep/lhef"
+)
+
+func root2lhe(ifname, tname string, verbose bool) {
+	ofname := strings.TrimSuffix(ifname, filepath.Ext(ifname)) + "_back.lhe"
+	n, err := writeLHE(ifname, ofname, tname, verbose)
+	if err != nil {
+		log.Fatalf("could not convert %q: %+v", ifname, err)
+	}
+	fmt.Println(" --> Event loop is done:", n, "events converted and stored in", ofname)
+}
+
+// Convert the events of the tree tname of the ROOT file ifname into the
+// LHE file ofname, and return the number of converted events. The LHE
+// file is removed in case of error.
+func writeLHE(ifname, ofname, tname string, verbose bool) (n int64, err error) {
+	if ofname == ifname {
+		return 0, fmt.Errorf("output file %q would overwrite the input file", ofname)
+	}
+
+	// Open the ROOT file and get the event tree
+	fin, err := groot.Open(ifname)
+	if err != nil {
+		return 0, fmt.Errorf("could not open ROOT file: %w", err)
+	}
+	defer fin.Close()
+	obj, err := fin.Get(tname)
+	if err != nil {
+		return 0, fmt.Errorf("could not retrieve tree %q: %w", tname, err)
+	}
+	tree, ok := obj.(rtree.Tree)
+	if !ok {
+		return 0, fmt.Errorf("object %q is not a tree (%T)", tname, obj)
+	}
+
+	// The LHE encoder only writes the init block with the first event,
+	// so that an empty tree would give an invalid LHE file
+	if tree.Entries() == 0 {
+		return 0, fmt.Errorf("tree %q is empty", tname)
+	}
+	run, err := readRun(fin, tname+"_init")
+	if err != nil {
+		return 0, err
+	}
+
+	// Read the raw LHE record and the event weight
+	var (
+		rec   Record
+		w     float64
+		rvars = append(getRecordVariables(&rec), rtree.ReadVar{Name: "w_xec", Value: &w})
+	)
+	r, err := rtree.NewReader(tree, rvars)
+	if err != nil {
+		return 0, fmt.Errorf("could not create tree reader (was %q produced by lhe2root?): %w", ifname, err)
+	}
+	defer r.Close()
+
+	// Prepare the LHE encoder, with the init block if it was stored
+	fout, err := os.Create(ofname)
+	if err != nil {
+		return 0, fmt.Errorf("could not create LHE file: %w", err)
+	}
+	defer func() {
+		fout.Close()
+		if err != nil {
+			os.Remove(ofname)
+		}
+	}()
+	enc, err := lhef.NewEncoder(fout)
+	if err != nil {
+		return 0, fmt.Errorf("could not create LHE encoder: %w", err)
+	}
+	enc.Run = run
+
+	// Event loop
+	err = r.Read(func(ctx rtree.RCtx) error {
+		evt, err := getHEPEUP(rec, w)
+		if err != nil {
+			return fmt.Errorf("event %d: %w", ctx.Entry, err)
+		}
+		if verbose {
+			fmt.Println()
+			fmt.Println(*evt)
+		}
+		return enc.Encode(evt)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not convert events: %w", err)
+	}
+
+	// The encoder also closes the file
+	err = enc.Close()
+	if err != nil {
+		return 0, fmt.Errorf("could not close LHE encoder: %w", err)
+	}
+
+	return tree.Entries(), nil
+}
+
+// Build a HEPEUP block from the raw record. Trees converted from HepMC
+// have no particle in the raw record, and cannot be converted back.
+func getHEPEUP(r Record, w float64) (*lhef.HEPEUP, error) {
+	n := int(r.nup)
+	if n <= 0 {
+		return nil, fmt.Errorf("no raw LHE record (was the tree converted from HepMC?)")
+	}
+	for _, l := range []int{
+		len(r.idup), len(r.istup), len(r.moth1), len(r.moth2), len(r.icol1), len(r.icol2),
+		len(r.px), len(r.py), len(r.pz), len(r.e), len(r.m), len(r.vtimup), len(r.spinup),
+	} {
+		if l != n {
+			return nil, fmt.Errorf("raw LHE record with %d particles instead of %d", l, n)
+		}
+	}
+	evt := &lhef.HEPEUP{
+		NUP:    r.nup,
+		IDPRUP: r.idprup,
+		XWGTUP: w,
+		SCALUP: r.scalup,
+		AQEDUP: r.aqedup,
+		AQCDUP: r.aqcdup,
+		IDUP:   make([]int64, n),
+		ISTUP:  make([]int32, n),
+		MOTHUP: make([][2]int32, n),
+		ICOLUP: make([][2]int32, n),
+		PUP:    make([][5]float64, n),
+		VTIMUP: make([]float64, n),
+		SPINUP: make([]float64, n),
+	}
+	for i := 0; i < n; i++ {
+		evt.IDUP[i] = int64(r.idup[i])
+		evt.ISTUP[i] = r.istup[i]
+		evt.MOTHUP[i] = [2]int32{r.moth1[i], r.moth2[i]}
+		evt.ICOLUP[i] = [2]int32{r.icol1[i], r.icol2[i]}
+		evt.PUP[i] = [5]float64{r.px[i], r.py[i], r.pz[i], r.e[i], r.m[i]}
+		evt.VTIMUP[i] = r.vtimup[i]
+		evt.SPINUP[i] = r.spinup[i]
+	}
+	return evt, nil
+}
+
+// Read the HEPRUP init block, if present in the file
+func readRun(f *groot.File, tname string) (lhef.HEPRUP, error) {
+	var run lhef.HEPRUP
+
+	obj, err := f.Get(tname)
+	if err != nil {
+		log.Printf("no init block %q found, writing an empty one", tname)
+		return run, nil
+	}
+	tree, ok := obj.(rtree.Tree)
+	if !ok {
+		return run, fmt.Errorf("object %q is not a tree (%T)", tname, obj)
+	}
+
+	var rr Run
+	rvars := []rtree.ReadVar{
+		{Name: "idbmup", Value: &rr.idbmup},
+		{Name: "ebmup", Value: &rr.ebmup},
+		{Name: "pdfgup", Value: &rr.pdfgup},
+		{Name: "pdfsup", Value: &rr.pdfsup},
+		{Name: "idwtup", Value: &rr.idwtup},
+		{Name: "nprup", Value: &rr.nprup},
+		{Name: "xsecup", Value: &rr.xsecup},
+		{Name: "xerrup", Value: &rr.xerrup},
+		{Name: "xmaxup", Value: &rr.xmaxup},
+		{Name: "lprup", Value: &rr.lprup},
+	}
+	r, err := rtree.NewReader(tree, rvars, rtree.WithRange(0, 1))
+	if err != nil {
+		return run, fmt.Errorf("could not create init tree reader: %w", err)
+	}
+	defer r.Close()
+	err = r.Read(func(ctx rtree.RCtx) error {
+		for i := 0; i < 2; i++ {
+			run.IDBMUP[i] = int64(rr.idbmup[i])
+			run.EBMUP[i] = rr.ebmup[i]
+			run.PDFGUP[i] = rr.pdfgup[i]
+			run.PDFSUP[i] = rr.pdfsup[i]
+		}
+		run.IDWTUP = rr.idwtup
+		run.NPRUP = rr.nprup
+		run.XSECUP = append([]float64(nil), rr.xsecup...)
+		run.XERRUP = append([]float64(nil), rr.xerrup...)
+		run.XMAXUP = append([]float64(nil), rr.xmaxup...)
+		run.LPRUP = append([]int32(nil), rr.lprup...)
+		return nil
+	})
+	if err != nil {
+		return run, fmt.Errorf("could not read init block: %w", err)
+	}
+
+	return run, nil
+}
+
+// Helper to define the raw LHE record variables to load
+func getRecordVariables(r *Record) []rtree.ReadVar {
+	return []rtree.ReadVar{
+		{Name: "lhe_nup", Value: &r.nup},
+		{Name: "lhe_idprup", Value: &r.idprup},
+		{Name: "lhe_scalup", Value: &r.scalup},
+		{Name: "lhe_aqedup", Value: &r.aqedup},
+		{Name: "lhe_aqcdup", Value: &r.aqcdup},
+		{Name: "lhe_idup", Value: &r.idup},
+		{Name: "lhe_istup", Value: &r.istup},
+		{Name: "lhe_moth1", Value: &r.moth1},
+		{Name: "lhe_moth2", Value: &r.moth2},
+		{Name: "lhe_icol1", Value: &r.icol1},
+		{Name: "lhe_icol2", Value: &r.icol2},
+		{Name: "lhe_px", Value: &r.px},
+		{Name: "lhe_py", Value: &r.py},
+		{Name: "lhe_pz", Value: &r.pz},
+		{Name: "lhe_e", Value: &r.e},
+		{Name: "lhe_m", Value: &r.m},
+		{Name: "lhe_vtimup", Value: &r.vtimup},
+		{Name: "lhe_spinup", Value: &r.spinup},
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go-hep.org/x/hep/groot"
+	"go-hep.org/x/hep/groot/rtree"
+	"go-hep.org/x/hep/lhef"
+)
+
+// Convert the LHE file ifname into the ROOT file ofname with nworkers
+// converters, as done by main with the default mapping and layout
+func lhe2root(t *testing.T, ifname, ofname, tname string, nworkers int) {
+	t.Helper()
+
+	f, err := os.Open(ifname)
+	if err != nil {
+		t.Fatalf("could not open LHE file: %+v", err)
+	}
+	defer f.Close()
+	dec, in, err := newLHEInput(f, 0)
+	if err != nil {
+		t.Fatalf("could not create LHE decoder: %+v", err)
+	}
+
+	var (
+		m   = &defaultMapping
+		lay = Layout{}
+		e   = newEvent(m)
+		src = &lheSource{dec: dec, m: m, lay: lay}
+	)
+	fout, err := groot.Create(ofname)
+	if err != nil {
+		t.Fatalf("could not create ROOT file: %+v", err)
+	}
+	tw, err := rtree.NewWriter(fout, tname, setBranches(&e, m, lay))
+	if err != nil {
+		t.Fatalf("could not create tree-writer: %+v", err)
+	}
+	writeRun(fout, tname+"_init", dec.Run)
+	w := &rootWriter{f: fout, tw: tw}
+
+	_, err = runPipeline(src, nworkers, in, func(evt Event, off int64) error {
+		e.assign(evt)
+		return w.write()
+	})
+	if err != nil {
+		t.Fatalf("could not convert events: %+v", err)
+	}
+	err = w.close()
+	if err != nil {
+		t.Fatalf("could not close ROOT file: %+v", err)
+	}
+}
+
+// Decode the init block and the events of a LHE file
+func readLHE(t *testing.T, fname string) (lhef.HEPRUP, []lhef.HEPEUP) {
+	t.Helper()
+
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatalf("could not open LHE file: %+v", err)
+	}
+	defer f.Close()
+	dec, err := lhef.NewDecoder(f)
+	if err != nil {
+		t.Fatalf("could not create LHE decoder for %q: %+v", fname, err)
+	}
+
+	var evts []lhef.HEPEUP
+	for {
+		evt, err := dec.Decode()
+		if err != nil {
+			break
+		}
+		evts = append(evts, *evt)
+	}
+	return dec.Run, evts
+}
+
+// Write the events of testdata/ttbar.lhe into fname, with distinct
+// lifetimes and spins
+func writeSpinLHE(t *testing.T, fname string) {
+	t.Helper()
+
+	run, evts := readLHE(t, filepath.Join("testdata", "ttbar.lhe"))
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatalf("could not create LHE file: %+v", err)
+	}
+	enc, err := lhef.NewEncoder(f)
+	if err != nil {
+		t.Fatalf("could not create LHE encoder: %+v", err)
+	}
+	enc.Run = run
+	for i := range evts {
+		evt := evts[i]
+		for j := range evt.SPINUP {
+			evt.VTIMUP[j] = 1e-3 * float64(10*i+j+1)
+			evt.SPINUP[j] = float64(1 - 2*(j%2))
+		}
+		err = enc.Encode(&evt)
+		if err != nil {
+			t.Fatalf("could not encode event %d: %+v", i, err)
+		}
+	}
+	err = enc.Close()
+	if err != nil {
+		t.Fatalf("could not close LHE file: %+v", err)
+	}
+}
+
+func TestRoot2LHERoundTrip(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		ifname = filepath.Join(dir, "ttbar.lhe")
+		rname  = filepath.Join(dir, "ttbar.root")
+		ofname = filepath.Join(dir, "ttbar_back.lhe")
+	)
+	writeSpinLHE(t, ifname)
+	lhe2root(t, ifname, rname, "truth", 2)
+	n, err := writeLHE(rname, ofname, "truth", false)
+	if err != nil {
+		t.Fatalf("could not convert back to LHE: %+v", err)
+	}
+
+	run, want := readLHE(t, ifname)
+	back, got := readLHE(t, ofname)
+	if len(want) == 0 {
+		t.Fatalf("no event in %q", ifname)
+	}
+	if n != int64(len(want)) || len(got) != len(want) {
+		t.Fatalf("invalid number of events: got %d (%d decoded), want %d", n, len(got), len(want))
+	}
+
+	if back.IDBMUP != run.IDBMUP || back.EBMUP != run.EBMUP ||
+		back.PDFGUP != run.PDFGUP || back.PDFSUP != run.PDFSUP ||
+		back.IDWTUP != run.IDWTUP || back.NPRUP != run.NPRUP ||
+		!reflect.DeepEqual(back.XSECUP, run.XSECUP) || !reflect.DeepEqual(back.XERRUP, run.XERRUP) ||
+		!reflect.DeepEqual(back.XMAXUP, run.XMAXUP) || !reflect.DeepEqual(back.LPRUP, run.LPRUP) {
+		t.Fatalf("invalid init block:\ngot:  %+v\nwant: %+v", back, run)
+	}
+
+	for i := range want {
+		g, w := got[i], want[i]
+		switch {
+		case g.NUP != w.NUP:
+			t.Errorf("event %d: invalid NUP: got %d, want %d", i, g.NUP, w.NUP)
+		case g.IDPRUP != w.IDPRUP:
+			t.Errorf("event %d: invalid IDPRUP: got %d, want %d", i, g.IDPRUP, w.IDPRUP)
+		case !closeTo(g.XWGTUP, w.XWGTUP):
+			t.Errorf("event %d: invalid weight: got %g, want %g", i, g.XWGTUP, w.XWGTUP)
+		case !closeTo(g.SCALUP, w.SCALUP) || !closeTo(g.AQEDUP, w.AQEDUP) || !closeTo(g.AQCDUP, w.AQCDUP):
+			t.Errorf("event %d: invalid scales: got (%g, %g, %g), want (%g, %g, %g)",
+				i, g.SCALUP, g.AQEDUP, g.AQCDUP, w.SCALUP, w.AQEDUP, w.AQCDUP)
+		case !reflect.DeepEqual(g.IDUP, w.IDUP):
+			t.Errorf("event %d: invalid IDUP:\ngot:  %v\nwant: %v", i, g.IDUP, w.IDUP)
+		case !reflect.DeepEqual(g.ISTUP, w.ISTUP):
+			t.Errorf("event %d: invalid ISTUP:\ngot:  %v\nwant: %v", i, g.ISTUP, w.ISTUP)
+		case !reflect.DeepEqual(g.MOTHUP, w.MOTHUP):
+			t.Errorf("event %d: invalid MOTHUP:\ngot:  %v\nwant: %v", i, g.MOTHUP, w.MOTHUP)
+		case !reflect.DeepEqual(g.ICOLUP, w.ICOLUP):
+			t.Errorf("event %d: invalid ICOLUP:\ngot:  %v\nwant: %v", i, g.ICOLUP, w.ICOLUP)
+		case !reflect.DeepEqual(g.VTIMUP, w.VTIMUP):
+			t.Errorf("event %d: invalid VTIMUP:\ngot:  %v\nwant: %v", i, g.VTIMUP, w.VTIMUP)
+		case !reflect.DeepEqual(g.SPINUP, w.SPINUP):
+			t.Errorf("event %d: invalid SPINUP:\ngot:  %v\nwant: %v", i, g.SPINUP, w.SPINUP)
+		}
+		for j := range w.PUP {
+			for k := range w.PUP[j] {
+				if !closeTo(g.PUP[j][k], w.PUP[j][k]) {
+					t.Errorf("event %d: invalid PUP[%d][%d]: got %g, want %g", i, j, k, g.PUP[j][k], w.PUP[j][k])
+				}
+			}
+		}
+	}
+}
+
+func TestRoot2LHEEmptyTree(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		rname  = filepath.Join(dir, "empty.root")
+		ofname = filepath.Join(dir, "empty_back.lhe")
+		e      = newEvent(&defaultMapping)
+	)
+	fout, err := groot.Create(rname)
+	if err != nil {
+		t.Fatalf("could not create ROOT file: %+v", err)
+	}
+	tw, err := rtree.NewWriter(fout, "truth", setBranches(&e, &defaultMapping, Layout{}))
+	if err != nil {
+		t.Fatalf("could not create tree-writer: %+v", err)
+	}
+	err = (&rootWriter{f: fout, tw: tw}).close()
+	if err != nil {
+		t.Fatalf("could not close ROOT file: %+v", err)
+	}
+
+	_, err = writeLHE(rname, ofname, "truth", false)
+	if err == nil {
+		t.Fatalf("expected an error for an empty tree")
+	}
+	if _, err := os.Stat(ofname); !os.IsNotExist(err) {
+		t.Fatalf("LHE file %q should not have been created", ofname)
+	}
+}
+
+func TestRoot2LHENoRecord(t *testing.T) {
+	var (
+		dir    = t.TempDir()
+		rname  = filepath.Join(dir, "hepmc.root")
+		ofname = filepath.Join(dir, "hepmc_back.lhe")
+		e      = newEvent(&defaultMapping)
+	)
+	fout, err := groot.Create(rname)
+	if err != nil {
+		t.Fatalf("could not create ROOT file: %+v", err)
+	}
+	tw, err := rtree.NewWriter(fout, "truth", setBranches(&e, &defaultMapping, Layout{}))
+	if err != nil {
+		t.Fatalf("could not create tree-writer: %+v", err)
+	}
+	w := &rootWriter{f: fout, tw: tw}
+
+	// Event converted from HepMC: no raw LHE record
+	e.w = 1
+	err = w.write()
+	if err != nil {
+		t.Fatalf("could not write event: %+v", err)
+	}
+	err = w.close()
+	if err != nil {
+		t.Fatalf("could not close ROOT file: %+v", err)
+	}
+
+	_, err = writeLHE(rname, ofname, "truth", false)
+	if err == nil {
+		t.Fatalf("expected an error for a tree without raw LHE record")
+	}
+	if _, err := os.Stat(ofname); !os.IsNotExist(err) {
+		t.Fatalf("LHE file %q should have been removed", ofname)
+	}
+}
+
+func TestRoot2LHESameFile(t *testing.T) {
+	_, err := writeLHE("ttbar.lhe", "ttbar.lhe", "truth", false)
+	if err == nil {
+		t.Fatalf("expected an error when the output overwrites the input")
+	}
+}
+
+// Are two values equal within the precision of the LHE format?
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
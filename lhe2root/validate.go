@@ -0,0 +1,151 @@
+// Consistency checks of the LHE records
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"go-hep.org/x/hep/lhef"
+)
+
+// Failed checks, stored as a bit mask in the lhe_bad branch
+const (
+	badLength   int32 = 1 << iota // NUP and particle arrays lengths differ
+	badMother                     // mother indices out of range
+	badMomentum                   // four-momentum not conserved
+	badMass                       // four-momentum inconsistent with MUP
+)
+
+var badNames = []struct {
+	bit  int32
+	name string
+}{
+	{badLength, "NUP/IDUP length"},
+	{badMother, "mother indices"},
+	{badMomentum, "momentum conservation"},
+	{badMass, "on-shell mass"},
+}
+
+// Validation of the LHE records, with tol the relative tolerance
+// on the momentum conservation and on the masses
+type validator struct {
+	tol  float64
+	drop bool // drop bad events instead of flagging them
+
+	nEvt   int
+	nBad   int
+	counts map[int32]int
+}
+
+func newValidator(mode string, tol float64) (*validator, error) {
+	v := &validator{tol: tol, counts: make(map[int32]int)}
+	switch mode {
+	case "flag":
+	case "drop":
+		v.drop = true
+	default:
+		return nil, fmt.Errorf("invalid validation mode %q (expected flag or drop)", mode)
+	}
+	return v, nil
+}
+
+// Check that the particle arrays of the decoded event all have NUP
+// entries, which is needed to build its record
+func checkLengths(evt *lhef.HEPEUP) error {
+	n := int(evt.NUP)
+	for _, l := range []struct {
+		name string
+		n    int
+	}{
+		{"IDUP", len(evt.IDUP)}, {"ISTUP", len(evt.ISTUP)},
+		{"MOTHUP", len(evt.MOTHUP)}, {"ICOLUP", len(evt.ICOLUP)},
+		{"PUP", len(evt.PUP)}, {"VTIMUP", len(evt.VTIMUP)},
+		{"SPINUP", len(evt.SPINUP)},
+	} {
+		if l.n != n {
+			return fmt.Errorf("NUP is %d but %s has %d entries", n, l.name, l.n)
+		}
+	}
+	return nil
+}
+
+// Check the record, whose lengths are consistent by construction (see
+// checkLengths), and return the mask of failed checks
+func (v *validator) check(r *Record) int32 {
+	var (
+		n          = int(r.nup)
+		bad        int32
+		pin, pout  [4]float64
+		ein        float64
+		validIndex = func(i, self int) bool { return i >= 1 && i <= n && i != self }
+	)
+	for i := 0; i < n; i++ {
+		p := [4]float64{r.px[i], r.py[i], r.pz[i], r.e[i]}
+
+		// Mother indices: none for incoming particles,
+		// a valid (range of) index otherwise
+		m1, m2 := int(r.moth1[i]), int(r.moth2[i])
+		switch r.istup[i] {
+		case -1:
+			if m1 != 0 || m2 != 0 {
+				bad |= badMother
+			}
+		default:
+			if !validIndex(m1, i+1) || (m2 != 0 && (!validIndex(m2, i+1) || m2 < m1)) {
+				bad |= badMother
+			}
+		}
+
+		// Four-momentum balance between incoming and final state particles
+		switch r.istup[i] {
+		case -1:
+			for j := range p {
+				pin[j] += p[j]
+			}
+			ein += p[3]
+		case 1:
+			for j := range p {
+				pout[j] += p[j]
+			}
+		}
+
+		// Mass from the four-momentum compared to MUP
+		m2calc := p[3]*p[3] - p[0]*p[0] - p[1]*p[1] - p[2]*p[2]
+		if math.Abs(m2calc-r.m[i]*r.m[i]) > v.tol*p[3]*p[3] {
+			bad |= badMass
+		}
+	}
+	for j := range pin {
+		if math.Abs(pin[j]-pout[j]) > v.tol*ein {
+			bad |= badMomentum
+		}
+	}
+
+	return bad
+}
+
+// Account for an event in the summary
+func (v *validator) count(bad int32) {
+	v.nEvt++
+	if bad == 0 {
+		return
+	}
+	v.nBad++
+	for _, b := range badNames {
+		if bad&b.bit != 0 {
+			v.counts[b.bit]++
+		}
+	}
+}
+
+// Print the validation summary
+func (v *validator) report() {
+	action := "flagged in lhe_bad"
+	if v.drop {
+		action = "dropped"
+	}
+	fmt.Printf(" --> Validation: %d/%d bad events (%s)\n", v.nBad, v.nEvt, action)
+	for _, b := range badNames {
+		fmt.Printf("     - %-22s: %d\n", b.name, v.counts[b.bit])
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"go-hep.org/x/hep/lhef"
+)
+
+func TestConvertBadLength(t *testing.T) {
+	evt := &lhef.HEPEUP{
+		NUP:    2,
+		XWGTUP: 0.5,
+		IDUP:   []int64{21, 21},
+		ISTUP:  []int32{-1},
+		MOTHUP: make([][2]int32, 2),
+		ICOLUP: make([][2]int32, 2),
+		PUP:    make([][5]float64, 2),
+		VTIMUP: make([]float64, 2),
+		SPINUP: make([]float64, 2),
+	}
+
+	// Without validation, the event cannot be converted
+	src := &lheSource{m: &defaultMapping}
+	_, err := src.convert(evt)
+	if err == nil {
+		t.Fatalf("expected an error for inconsistent lengths")
+	}
+
+	// With validation, the event is flagged
+	val, err := newValidator("flag", 1e-6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.val = val
+	e, err := src.convert(evt)
+	if err != nil {
+		t.Fatalf("could not convert event: %+v", err)
+	}
+	if e.bad != badLength || e.w != evt.XWGTUP {
+		t.Fatalf("invalid flagged event: bad=%d w=%g", e.bad, e.w)
+	}
+}
+
+// g g -> t tbar event, with the masses given by the four-momenta
+func validEvent() *lhef.HEPEUP {
+	evt := &lhef.HEPEUP{
+		NUP:    4,
+		XWGTUP: 1,
+		IDUP:   []int64{21, 21, 6, -6},
+		ISTUP:  []int32{-1, -1, 1, 1},
+		MOTHUP: [][2]int32{{0, 0}, {0, 0}, {1, 2}, {1, 2}},
+		ICOLUP: [][2]int32{{101, 102}, {103, 101}, {103, 0}, {0, 102}},
+		PUP: [][5]float64{
+			{0, 0, 500, 500, 0},
+			{0, 0, -300, 300, 0},
+			{50, 20, 150, 400, 0},
+			{-50, -20, 50, 400, 0},
+		},
+		VTIMUP: make([]float64, 4),
+		SPINUP: []float64{9, 9, 9, 9},
+	}
+	for i, p := range evt.PUP {
+		evt.PUP[i][4] = massOf(p)
+	}
+	return evt
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(evt *lhef.HEPEUP)
+		tol    float64
+		want   int32
+	}{
+		{"valid", func(evt *lhef.HEPEUP) {}, 1e-6, 0},
+		{"mother of incoming", func(evt *lhef.HEPEUP) { evt.MOTHUP[0] = [2]int32{2, 0} }, 1e-6, badMother},
+		{"mother out of range", func(evt *lhef.HEPEUP) { evt.MOTHUP[2] = [2]int32{1, 5} }, 1e-6, badMother},
+		{"self mother", func(evt *lhef.HEPEUP) { evt.MOTHUP[3] = [2]int32{4, 0} }, 1e-6, badMother},
+		{"reversed mothers", func(evt *lhef.HEPEUP) { evt.MOTHUP[3] = [2]int32{2, 1} }, 1e-6, badMother},
+		{"momentum", func(evt *lhef.HEPEUP) { evt.PUP[2][0] = 60; evt.PUP[2][4] = massOf(evt.PUP[2]) }, 1e-6, badMomentum},
+		{"mass", func(evt *lhef.HEPEUP) { evt.PUP[2][4] = 173 }, 1e-6, badMass},
+		{"mass and momentum", func(evt *lhef.HEPEUP) { evt.PUP[1][2] = -301 }, 1e-6, badMass | badMomentum},
+
+		// Differences of 1e-2 on px and on the mass are above the
+		// default tolerance, and within a tolerance of 1e-4
+		{"small momentum", func(evt *lhef.HEPEUP) { evt.PUP[2][0] += 1e-2; evt.PUP[2][4] = massOf(evt.PUP[2]) }, 1e-6, badMomentum},
+		{"small momentum within tolerance", func(evt *lhef.HEPEUP) { evt.PUP[2][0] += 1e-2; evt.PUP[2][4] = massOf(evt.PUP[2]) }, 1e-4, 0},
+		{"small mass", func(evt *lhef.HEPEUP) { evt.PUP[3][4] += 1e-2 }, 1e-6, badMass},
+		{"small mass within tolerance", func(evt *lhef.HEPEUP) { evt.PUP[3][4] += 1e-2 }, 1e-4, 0},
+	} {
+		val, err := newValidator("flag", tc.tol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		evt := validEvent()
+		tc.modify(evt)
+		src := &lheSource{m: &defaultMapping, val: val}
+		e, err := src.convert(evt)
+		if err != nil {
+			t.Fatalf("%s: could not convert event: %+v", tc.name, err)
+		}
+		if e.bad != tc.want {
+			t.Errorf("%s: invalid checks: got %04b, want %04b", tc.name, e.bad, tc.want)
+		}
+	}
+}
+
+// Mass given by a LHE four-momentum
+func massOf(p [5]float64) float64 {
+	return math.Sqrt(p[3]*p[3] - p[0]*p[0] - p[1]*p[1] - p[2]*p[2])
+}
@@ -0,0 +1,202 @@
+// Selection of the copied input branches and derived variables (aliases)
+// of the output tree, declared as expressions at run time
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go-hep.org/x/hep/fmom"
+	"go-hep.org/x/hep/groot/rtree"
+
+	"github.com/rmadar/go-simple-examples/internal/expr"
+)
+
+// Input branches copied by default
+const defaultBranches = "t_pt,tbar_pt,t_pid,tbar_pid,l_pid,lbar_pid"
+
+// Select the input branches matching a comma separated list of
+// patterns (e.g. "t_*,b_pt"), in the order of the patterns
+func selectBranches(tree rtree.Tree, spec string) ([]string, error) {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		n := 0
+		for _, b := range tree.Branches() {
+			ok, err := filepath.Match(pattern, b.Name())
+			if err != nil {
+				return nil, fmt.Errorf("invalid branch pattern %q: %w", pattern, err)
+			}
+			if !ok {
+				continue
+			}
+			n++
+			if !seen[b.Name()] {
+				seen[b.Name()] = true
+				names = append(names, b.Name())
+			}
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("no branch matching %q in tree %q", pattern, tree.Name())
+		}
+	}
+	return names, nil
+}
+
+// Write variables of the input branches selected by spec. The branches
+// which are not read yet (including the counts of the slices) are added
+// to rvars, and the values already read are shared.
+func copiedBranches(tree rtree.Tree, spec string, rvars *[]rtree.ReadVar) ([]rtree.WriteVar, error) {
+	names, err := selectBranches(tree, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		all    = make(map[string]rtree.WriteVar)
+		copied []rtree.WriteVar
+		done   = make(map[string]bool)
+	)
+	for _, wv := range rtree.WriteVarsFromTree(tree) {
+		all[wv.Name] = wv
+	}
+	var add func(name string)
+	add = func(name string) {
+		if done[name] {
+			return
+		}
+		done[name] = true
+		wv := all[name]
+		if wv.Count != "" {
+			add(wv.Count)
+		}
+		shared := false
+		for _, rv := range *rvars {
+			if rv.Name == name {
+				wv.Value, shared = rv.Value, true
+			}
+		}
+		if !shared {
+			*rvars = append(*rvars, rtree.ReadVar{Name: name, Value: wv.Value})
+		}
+		copied = append(copied, wv)
+	}
+	for _, name := range names {
+		add(name)
+	}
+	return copied, nil
+}
+
+// Check that the names of the output branches are unique
+func checkNames(wvars []rtree.WriteVar) error {
+	seen := make(map[string]bool)
+	for _, wv := range wvars {
+		if seen[wv.Name] {
+			return fmt.Errorf("duplicate branch %q", wv.Name)
+		}
+		seen[wv.Name] = true
+	}
+	return nil
+}
+
+// Derived variable of the output tree, e.g. "mtt = M(t+tbar)"
+type alias struct {
+	name  string
+	expr  string
+	eval  func() float64
+	value float64
+}
+
+// Parse the aliases given as "name=expression", followed by the ones
+// of the file fname (one per line, # comments), if any
+func parseAliases(defs []string, fname string) ([]alias, error) {
+	if fname != "" {
+		f, err := os.Open(fname)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := sc.Text()
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			if line = strings.TrimSpace(line); line != "" {
+				defs = append(defs, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("could not read aliases file %q: %w", fname, err)
+		}
+	}
+
+	var aliases []alias
+	for _, def := range defs {
+		i := strings.Index(def, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid alias %q (expected name=expression)", def)
+		}
+		a := alias{name: strings.TrimSpace(def[:i]), expr: strings.TrimSpace(def[i+1:])}
+		if !token.IsIdentifier(a.name) {
+			return nil, fmt.Errorf("invalid alias name %q", a.name)
+		}
+		_, err := expr.Parse(a.expr)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse alias %s = %q: %w", a.name, a.expr, err)
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, nil
+}
+
+// Compile the aliases of the event e. Expressions can use the scalar
+// branches vars, the previous aliases and the four-vectors of the
+// particles (t, tbar, b, bbar, W, Wbar, l, lbar, v, vbar).
+func compileAliases(aliases []alias, e *Event, vars map[string]func() float64) ([]*alias, error) {
+	env := expr.Env{Vars: vars, Vecs: make(map[string]func() fmom.PxPyPzE)}
+	for _, p := range e.particles() {
+		part := p.part
+		env.Vecs[p.name] = func() fmom.PxPyPzE { return get4Vec(*part) }
+	}
+
+	var out []*alias
+	for i := range aliases {
+		a := aliases[i]
+		eval, err := expr.Number(a.expr, env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alias %s = %q: %w", a.name, a.expr, err)
+		}
+		a.eval = eval
+		vars[a.name] = func() float64 { return a.value }
+		out = append(out, &a)
+	}
+	return out, nil
+}
+
+// Scalar variables of the branches of the tree
+func scalarVars(rvars []rtree.ReadVar, wvars []rtree.WriteVar) map[string]func() float64 {
+	vars := make(map[string]func() float64)
+	add := func(name string, ptr interface{}) {
+		if v := expr.Scalar(ptr); v != nil {
+			vars[name] = v
+		}
+	}
+	for _, rv := range rvars {
+		add(rv.Name, rv.Value)
+	}
+	for _, wv := range wvars {
+		add(wv.Name, wv.Value)
+	}
+	return vars
+}
@@ -0,0 +1,293 @@
+// Processing of a chain of input files with a pool of workers
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go-hep.org/x/hep/groot"
+	"go-hep.org/x/hep/groot/rtree"
+	"go-hep.org/x/hep/hbook"
+
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/spin"
+)
+
+// Get the list of input files from a comma separated list of
+// file names or glob patterns, each pattern being sorted
+func expandInputs(spec string) ([]string, error) {
+	var fnames []string
+	for _, pattern := range strings.Split(spec, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no file matching %q", pattern)
+		}
+		fnames = append(fnames, matches...)
+	}
+	if len(fnames) == 0 {
+		return nil, fmt.Errorf("no input file")
+	}
+	return fnames, nil
+}
+
+// Name of the temporary output of the i-th input file
+func partName(fnameOut string, i int) string {
+	return fmt.Sprintf("%s_part%03d.root", strings.TrimSuffix(fnameOut, ".root"), i)
+}
+
+// Index of the first entry of each file in the chain
+func chainOffsets(fnames []string, tname string) ([]int64, error) {
+	var (
+		offsets = make([]int64, len(fnames))
+		n       int64
+	)
+	for i, fname := range fnames {
+		f, tree, err := openTree(fname, tname)
+		if err != nil {
+			return nil, err
+		}
+		offsets[i] = n
+		n += tree.Entries()
+		f.Close()
+	}
+	return offsets, nil
+}
+
+// Run the event loop over each file with nworkers concurrent workers.
+// The results are returned in the order of the input files. The i-th
+// file uses the random seed cfg.seed+i, whatever the number of workers.
+// If a file fails, no new file is started, the outputs of all the files
+// are removed and the error of the first failed file is returned.
+func processFiles(fnames []string, fnameOut string, cfg config, nworkers int) ([]*fileResult, error) {
+
+	if nworkers < 1 {
+		nworkers = 1
+	}
+	offsets, err := chainOffsets(fnames, cfg.tname)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		res    = make([]*fileResult, len(fnames))
+		errs   = make([]error, len(fnames))
+		jobs   = make(chan int)
+		cancel = make(chan struct{}) // closed on the first error
+		once   sync.Once
+		wg     sync.WaitGroup
+	)
+	wg.Add(nworkers)
+	for w := 0; w < nworkers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				select {
+				case <-cancel:
+					continue
+				default:
+				}
+				c := cfg
+				c.seed += int64(i)
+				c.offset = offsets[i]
+				res[i], errs[i] = eventLoop(fnames[i], partName(fnameOut, i), c)
+				if errs[i] != nil {
+					once.Do(func() { close(cancel) })
+				}
+			}
+		}()
+	}
+loop:
+	for i := range fnames {
+		select {
+		case jobs <- i:
+		case <-cancel:
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			removeParts(fnameOut, len(fnames))
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Remove the temporary outputs of the n input files
+func removeParts(fnameOut string, n int) {
+	for i := 0; i < n; i++ {
+		os.Remove(partName(fnameOut, i))
+		os.Remove(dumpName(partName(fnameOut, i)))
+	}
+}
+
+// Merge the trees and histograms of all the files into fnameOut, in
+// the order of the input files, and return the summed results. The
+// temporary outputs are removed and, on failure, fnameOut too.
+func mergeResults(res []*fileResult, fnameOut string, cfg config) (tot *fileResult, err error) {
+	defer removeParts(fnameOut, len(res))
+
+	tot = &fileResult{fname: fnameOut, hs: newHistos(cfg.reco)}
+	if cfg.unfold {
+		tot.unf = newUnfolding()
+	}
+	if cfg.entAxes != nil {
+		tot.ent = newEntanglement(cfg.entAxes...)
+	}
+	if cfg.diffAxes != nil {
+		tot.diff = newDifferential(cfg.diffAxes)
+	}
+	if cfg.whel {
+		tot.whel = spin.NewWHelicity(nbins)
+	}
+	for _, r := range res {
+		tot.nRead += r.nRead
+		tot.nEvt += r.nEvt
+		tot.nFallback += r.nFallback
+		tot.nRecoMiss += r.nRecoMiss
+		tot.nRecoFail += r.nRecoFail
+		tot.coeffs.Merge(&r.coeffs)
+		tot.hs.add(r.hs)
+		if tot.unf != nil {
+			tot.unf.merge(r.unf)
+		}
+		if cfg.rw != nil {
+			if tot.rwCoeffs == nil {
+				tot.rwCoeffs = make([]spin.Estimator, len(cfg.rw.hyps))
+			}
+			for k := range tot.rwCoeffs {
+				tot.rwCoeffs[k].Merge(&r.rwCoeffs[k])
+			}
+			tot.nRwBad += r.nRwBad
+		}
+		if tot.ent != nil {
+			tot.ent.merge(r.ent)
+		}
+		if tot.diff != nil {
+			tot.diff.merge(r.diff)
+		}
+		if tot.whel != nil {
+			tot.whel.Merge(r.whel)
+		}
+	}
+
+	// Unfolding of the summed responses
+	if tot.unf != nil {
+		tot.unfolded, err = tot.unf.run(cfg.niter, cfg.tau)
+		if err != nil {
+			return nil, fmt.Errorf("could not unfold the cosines: %w", err)
+		}
+		tot.hs.addUnfolding(tot.unf, tot.unfolded)
+	}
+	if tot.diff != nil {
+		tot.hs.addDifferential(tot.diff)
+	}
+	if tot.whel != nil {
+		tot.hs.addWHelicity(tot.whel)
+	}
+
+	fout, err := groot.Create(fnameOut)
+	if err != nil {
+		return nil, fmt.Errorf("could not create ROOT file %q: %w", fnameOut, err)
+	}
+	defer fout.Close()
+	defer func() {
+		if err != nil {
+			fout.Close()
+			os.Remove(fnameOut)
+		}
+	}()
+
+	var tout rtree.Writer
+	for i := range res {
+		err = copyTree(&tout, fout, partName(fnameOut, i), cfg.tname)
+		if err != nil {
+			return nil, fmt.Errorf("could not merge tree of %q: %w", res[i].fname, err)
+		}
+	}
+	err = tout.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not close tree-writer of %q: %w", fnameOut, err)
+	}
+
+	// Histograms, saved next to the tree
+	err = tot.hs.write(fout)
+	if err != nil {
+		return nil, fmt.Errorf("could not save histograms: %w", err)
+	}
+	err = fout.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not close ROOT file %q: %w", fnameOut, err)
+	}
+
+	return tot, nil
+}
+
+// Copy the tree tname of the file fname with the writer tout, created
+// in fout with the branches of the tree if needed
+func copyTree(tout *rtree.Writer, fout *groot.File, fname, tname string) error {
+	f, tree, err := openTree(fname, tname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if *tout == nil {
+		*tout, err = rtree.NewWriter(fout, tname, rtree.WriteVarsFromTree(tree))
+		if err != nil {
+			return fmt.Errorf("could not create tree-writer: %w", err)
+		}
+	}
+	r, err := rtree.NewReader(tree, rtree.NewReadVars(tree))
+	if err != nil {
+		return fmt.Errorf("could not create tree reader: %w", err)
+	}
+	defer r.Close()
+	_, err = rtree.Copy(*tout, r)
+	return err
+}
+
+// Add the content of the histograms of o
+func (hs *histos) add(o *histos) {
+	for i, h := range hs.list {
+		switch {
+		case h.h1 != nil:
+			*h.h1 = *hbook.AddH1D(h.h1, o.list[i].h1)
+		default:
+			addH2D(h.h2, o.list[i].h2)
+		}
+	}
+}
+
+// Add the content of o to h, both having the same binning
+func addH2D(h, o *hbook.H2D) {
+	for i := range h.Binning.Bins {
+		addDist2D(&h.Binning.Bins[i].Dist, o.Binning.Bins[i].Dist)
+	}
+	for i := range h.Binning.Outflows {
+		addDist2D(&h.Binning.Outflows[i], o.Binning.Outflows[i])
+	}
+	addDist2D(&h.Binning.Dist, o.Binning.Dist)
+}
+
+func addDist2D(d *hbook.Dist2D, o hbook.Dist2D) {
+	for _, p := range []struct{ d, o *hbook.Dist1D }{{&d.X, &o.X}, {&d.Y, &o.Y}} {
+		p.d.Dist.N += p.o.Dist.N
+		p.d.Dist.SumW += p.o.Dist.SumW
+		p.d.Dist.SumW2 += p.o.Dist.SumW2
+		p.d.Stats.SumWX += p.o.Stats.SumWX
+		p.d.Stats.SumWX2 += p.o.Stats.SumWX2
+	}
+	d.Stats.SumWXY += o.Stats.SumWXY
+}
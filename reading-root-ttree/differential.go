@@ -0,0 +1,238 @@
+// Differential measurement of the spin observables in bins of the
+// ttbar kinematics
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-hep.org/x/hep/fmom"
+	"go-hep.org/x/hep/hbook"
+
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/spin"
+)
+
+// Binned kinematic variable of the ttbar pair
+type axis struct {
+	name  string
+	label string
+	edges []float64
+	value func(t, tbar fmom.PxPyPzE) float64
+}
+
+// Kinematic variables available for the binning, with their default edges
+var kinVars = map[string]struct {
+	label string
+	edges string
+	value func(t, tbar fmom.PxPyPzE) float64
+}{
+	"mtt":       {"m_tt [GeV]", "300,400,450,500,600,800,3000", ttbarMass},
+	"pt_t":      {"pT(t) [GeV]", "0,50,100,150,200,300,1000", topPt},
+	"absy_tt":   {"|y_tt|", "0,0.25,0.5,0.75,1,1.5,2.5", ttbarAbsRapidity},
+	"cos_theta": {"cos(Theta)", "-1,-0.75,-0.5,-0.25,0,0.25,0.5,0.75,1", scatteringCos},
+	"beta":      {"beta", "0,0.2,0.4,0.6,0.8,0.9,1", topBeta},
+}
+
+// Parse an axis "name" (with the default edges) or "name=edges", the
+// edges being comma separated
+func parseAxis(s string) (axis, error) {
+	name, edges := s, ""
+	if i := strings.Index(s, "="); i >= 0 {
+		name, edges = strings.TrimSpace(s[:i]), s[i+1:]
+	}
+	v, ok := kinVars[name]
+	if !ok {
+		var names []string
+		for n := range kinVars {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return axis{}, fmt.Errorf("unknown variable %q (expected one of %s)", name, strings.Join(names, ", "))
+	}
+	if edges == "" {
+		edges = v.edges
+	}
+	xs, err := parseEdges(edges)
+	if err != nil {
+		return axis{}, fmt.Errorf("axis %s: %w", name, err)
+	}
+	return axis{name: name, label: v.label, edges: xs, value: v.value}, nil
+}
+
+// Parse comma separated increasing bin edges
+func parseEdges(s string) ([]float64, error) {
+	var edges []float64
+	for _, item := range strings.Split(s, ",") {
+		x, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bin edges %q: %w", s, err)
+		}
+		if len(edges) > 0 && x <= edges[len(edges)-1] {
+			return nil, fmt.Errorf("invalid bin edges %q (not increasing)", s)
+		}
+		edges = append(edges, x)
+	}
+	if len(edges) < 2 {
+		return nil, fmt.Errorf("invalid bin edges %q (at least 2 edges needed)", s)
+	}
+	return edges, nil
+}
+
+// Index of the bin of the event, -1 if outside of the edges
+func (a axis) bin(t, tbar fmom.PxPyPzE) int {
+	x := a.value(t, tbar)
+	for i := 0; i < len(a.edges)-1; i++ {
+		if x >= a.edges[i] && x < a.edges[i+1] {
+			return i
+		}
+	}
+	return -1
+}
+
+// Invariant mass of the ttbar pair
+func ttbarMass(t, tbar fmom.PxPyPzE) float64 {
+	return fmom.Add(&t, &tbar).M()
+}
+
+func topPt(t, tbar fmom.PxPyPzE) float64 {
+	return t.Pt()
+}
+
+// Absolute rapidity of the ttbar pair
+func ttbarAbsRapidity(t, tbar fmom.PxPyPzE) float64 {
+	return math.Abs(fmom.Add(&t, &tbar).Rapidity())
+}
+
+// Cosine of the angle between the top, in the ttbar rest frame, and the beam
+func scatteringCos(t, tbar fmom.PxPyPzE) float64 {
+	ttbar := fmom.Add(&t, &tbar)
+	top := fmom.Boost(&t, fmom.BoostOf(ttbar).Scale(-1))
+	return top.Pz() / top.P()
+}
+
+// Velocity of the top in the ttbar rest frame, from the two-body kinematics
+func topBeta(t, tbar fmom.PxPyPzE) float64 {
+	var (
+		m  = ttbarMass(t, tbar)
+		m1 = t.M()
+		m2 = tbar.M()
+	)
+	if m <= 0 {
+		return math.NaN()
+	}
+	p := math.Sqrt(math.Max((m*m-(m1+m2)*(m1+m2))*(m*m-(m1-m2)*(m1-m2)), 0)) / (2 * m)
+	e := (m*m + m1*m1 - m2*m2) / (2 * m)
+	return p / e
+}
+
+// Spin coefficients in the bins of each axis
+type differential struct {
+	axes   []axis
+	coeffs [][]spin.Estimator // [axis][bin]
+}
+
+func newDifferential(axes []axis) *differential {
+	d := &differential{axes: axes, coeffs: make([][]spin.Estimator, len(axes))}
+	for i, a := range axes {
+		d.coeffs[i] = make([]spin.Estimator, len(a.edges)-1)
+	}
+	return d
+}
+
+// Fill the coefficients of the bins of the event
+func (d *differential) fill(t, tbar fmom.PxPyPzE, c spin.Cosines, w float64) {
+	for i, a := range d.axes {
+		if ibin := a.bin(t, tbar); ibin >= 0 {
+			d.coeffs[i][ibin].Fill(c, w)
+		}
+	}
+}
+
+func (d *differential) merge(o *differential) {
+	for i := range d.coeffs {
+		for j := range d.coeffs[i] {
+			d.coeffs[i][j].Merge(&o.coeffs[i][j])
+		}
+	}
+}
+
+// Is the coefficient measured differentially?
+func diffCoeff(name string) bool {
+	return name == "D" || strings.HasPrefix(name, "C_")
+}
+
+// Add the histograms of C_ij and D versus each axis, in the differential directory
+func (hs *histos) addDifferential(d *differential) {
+	for i, a := range d.axes {
+		hists := make(map[string]*hbook.H1D)
+		for ibin := range d.coeffs[i] {
+			for _, r := range d.coeffs[i][ibin].Results() {
+				if !diffCoeff(r.Name) {
+					continue
+				}
+				h, ok := hists[r.Name]
+				if !ok {
+					h = hbook.NewH1DFromEdges(a.edges)
+					h.Ann["name"] = r.Name + "_vs_" + a.name
+					hs.list = append(hs.list, &histo{dir: "differential", xlabel: a.label, ylabel: r.Name, h1: h})
+					hists[r.Name] = h
+				}
+				if math.IsNaN(r.Value) {
+					continue
+				}
+				setBin(h, ibin, r.Value, r.Error)
+			}
+		}
+	}
+}
+
+// Print the coefficients of the bins and store them in a CSV file
+func reportDifferential(d *differential, fname string) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"variable", "low", "high", "events", "name", "value", "error"})
+	fmt.Println(" --> Differential spin coefficients")
+	for i, a := range d.axes {
+		for ibin := range d.coeffs[i] {
+			var (
+				lo, hi = a.edges[ibin], a.edges[ibin+1]
+				est    = &d.coeffs[i][ibin]
+				diag   []string
+			)
+			for _, r := range est.Results() {
+				if !diffCoeff(r.Name) {
+					continue
+				}
+				if r.Name == "D" || r.Name == "C_kk" || r.Name == "C_rr" || r.Name == "C_nn" {
+					diag = append(diag, fmt.Sprintf("%s = %+.3f +/- %.3f", r.Name, r.Value, r.Error))
+				}
+				w.Write([]string{
+					a.name,
+					strconv.FormatFloat(lo, 'g', -1, 64),
+					strconv.FormatFloat(hi, 'g', -1, 64),
+					strconv.Itoa(est.Events()),
+					r.Name,
+					strconv.FormatFloat(r.Value, 'g', -1, 64),
+					strconv.FormatFloat(r.Error, 'g', -1, 64),
+				})
+			}
+			bin := fmt.Sprintf("%s [%g, %g[", a.name, lo, hi)
+			fmt.Printf("     %-24s %7d events: %s\n", bin, est.Events(), strings.Join(diag, ", "))
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
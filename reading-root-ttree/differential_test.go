@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"go-hep.org/x/hep/fmom"
+
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/spin"
+)
+
+func TestParseAxis(t *testing.T) {
+	for _, tc := range []struct {
+		spec  string
+		name  string
+		edges []float64
+		err   bool
+	}{
+		{spec: "mtt", name: "mtt", edges: []float64{300, 400, 450, 500, 600, 800, 3000}},
+		{spec: "beta=0,0.5,1", name: "beta", edges: []float64{0, 0.5, 1}},
+		{spec: " pt_t = 0, 100 ,1e3", name: "pt_t", edges: []float64{0, 100, 1000}},
+		{spec: "mbb", err: true},
+		{spec: "mtt=", name: "mtt", edges: []float64{300, 400, 450, 500, 600, 800, 3000}},
+		{spec: "mtt=300", err: true},
+		{spec: "mtt=300,300", err: true},
+		{spec: "mtt=500,300", err: true},
+		{spec: "mtt=300,x", err: true},
+		{spec: "mtt=300,,500", err: true},
+	} {
+		a, err := parseAxis(tc.spec)
+		switch {
+		case tc.err && err == nil:
+			t.Errorf("%q: expected an error", tc.spec)
+		case !tc.err && err != nil:
+			t.Errorf("%q: could not parse axis: %+v", tc.spec, err)
+		case !tc.err && (a.name != tc.name || !reflect.DeepEqual(a.edges, tc.edges) || a.value == nil):
+			t.Errorf("%q: invalid axis: got %s %v, want %s %v", tc.spec, a.name, a.edges, tc.name, tc.edges)
+		}
+	}
+}
+
+func TestAxisBin(t *testing.T) {
+	// Axis on the px of the top
+	a := axis{name: "px", edges: []float64{0, 1, 2}, value: func(t, tbar fmom.PxPyPzE) float64 { return t.Px() }}
+	for _, tc := range []struct {
+		x    float64
+		want int
+	}{
+		{-0.5, -1},
+		{0, 0},
+		{0.5, 0},
+		{1, 1},
+		{1.999, 1},
+		{2, -1},
+		{3, -1},
+		{math.NaN(), -1},
+		{math.Inf(+1), -1},
+	} {
+		top := fmom.NewPxPyPzE(tc.x, 0, 0, 200)
+		if got := a.bin(top, top); got != tc.want {
+			t.Errorf("invalid bin of %g: got %d, want %d", tc.x, got, tc.want)
+		}
+	}
+
+	// Undefined velocity of massless tops at rest
+	beta, err := parseAxis("beta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zero fmom.PxPyPzE
+	if b := topBeta(zero, zero); !math.IsNaN(b) {
+		t.Errorf("invalid beta of null tops: got %g, want NaN", b)
+	}
+	if got := beta.bin(zero, zero); got != -1 {
+		t.Errorf("invalid bin of an undefined beta: got %d, want -1", got)
+	}
+}
+
+func TestAddDifferential(t *testing.T) {
+	a, err := parseAxis("mtt=300,500,800")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		d   = newDifferential([]axis{a})
+		rnd = rand.New(rand.NewSource(1))
+		u   = func() float64 { return 2*rnd.Float64() - 1 }
+	)
+	for _, e := range []float64{200, 300} {
+		p := math.Sqrt(e*e - 173*173)
+		top, antitop := fmom.NewPxPyPzE(0, 0, p, e), fmom.NewPxPyPzE(0, 0, -p, e)
+		for i := 0; i < 100; i++ {
+			c := spin.Cosines{KP: u(), RP: u(), NP: u(), KM: u(), RM: u(), NM: u(), Dphi: u()}
+			d.fill(top, antitop, c, 0.5+rnd.Float64())
+		}
+	}
+
+	hs := &histos{}
+	hs.addDifferential(d)
+	if len(hs.list) != 10 {
+		t.Fatalf("invalid number of histograms: got %d, want 10 (C_ij and D)", len(hs.list))
+	}
+
+	// Coefficients of each histogram, bin by bin
+	results := make(map[string][]spin.Result)
+	for ibin := range d.coeffs[0] {
+		for _, r := range d.coeffs[0][ibin].Results() {
+			results[r.Name+"_vs_mtt"] = append(results[r.Name+"_vs_mtt"], r)
+		}
+	}
+	for _, h := range hs.list {
+		var (
+			h1                 = h.h1
+			name               = h1.Name()
+			sumw, sumw2, sumwx float64
+		)
+		for ibin, r := range results[name] {
+			bin := h1.Binning.Bins[ibin]
+			if bin.SumW() != r.Value || math.Abs(bin.ErrW()-r.Error) > 1e-12 || bin.Entries() != 1 {
+				t.Errorf("%s: invalid bin %d: got %g +/- %g (%d entries), want %g +/- %g",
+					name, ibin, bin.SumW(), bin.ErrW(), bin.Entries(), r.Value, r.Error)
+			}
+			sumw += r.Value
+			sumw2 += r.Error * r.Error
+			sumwx += r.Value * bin.XMid()
+		}
+		if h1.Entries() != 2 || math.Abs(h1.SumW()-sumw) > 1e-12 ||
+			math.Abs(h1.SumW2()-sumw2) > 1e-12 || math.Abs(h1.SumWX()-sumwx) > 1e-9 {
+			t.Errorf("%s: inconsistent statistics: entries=%d sumw=%g sumw2=%g sumwx=%g, want 2 %g %g %g",
+				name, h1.Entries(), h1.SumW(), h1.SumW2(), h1.SumWX(), sumw, sumw2, sumwx)
+		}
+	}
+}
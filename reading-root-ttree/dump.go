@@ -0,0 +1,228 @@
+// Structured dumps of the events, as aligned tables or JSON lines
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Particle of an event with its name
+type namedParticle struct {
+	name string
+	part *Particle
+}
+
+// Particles of the event, in the order of the input branches
+func (e *Event) particles() []namedParticle {
+	return []namedParticle{
+		{"t", &e.t}, {"tbar", &e.tbar},
+		{"b", &e.b}, {"bbar", &e.bbar},
+		{"W", &e.W}, {"Wbar", &e.Wbar},
+		{"l", &e.l}, {"lbar", &e.lbar},
+		{"v", &e.v}, {"vbar", &e.vbar},
+	}
+}
+
+// Dump of the selected entries of the chain, in the table or json format
+type dumper struct {
+	format  string
+	entries [][2]int64 // ranges [beg, end) of the dumped entries
+	every   int64      // also dump one entry out of every (0: none)
+	all     bool       // dump all the entries
+}
+
+// Set up the dump of the entries (comma separated entries or ranges
+// beg:end) in a format (table or json). Without entries, all the
+// entries are dumped, or one out of 100 in verbose mode.
+func newDumper(format, entries string, verbose bool) (*dumper, error) {
+	d := &dumper{format: format}
+	switch format {
+	case "":
+		if entries == "" && !verbose {
+			return nil, nil
+		}
+		d.format = "table"
+	case "table", "json":
+	default:
+		return nil, fmt.Errorf("invalid dump format %q (expected table or json)", format)
+	}
+	for _, item := range strings.Split(entries, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, ":") {
+			entry, err := strconv.ParseInt(item, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid dumped entry %q: %w", item, err)
+			}
+			d.entries = append(d.entries, [2]int64{entry, entry + 1})
+			continue
+		}
+		first, n, err := parseRange(item)
+		if err != nil {
+			return nil, err
+		}
+		end := first + n
+		if n < 0 {
+			end = -1
+		}
+		d.entries = append(d.entries, [2]int64{first, end})
+	}
+	if verbose {
+		d.every = 100
+	}
+	d.all = d.entries == nil && d.every == 0
+	return d, nil
+}
+
+// Is the entry of the chain dumped? The entry is counted in the
+// ranges of requested entries it belongs to.
+func (d *dumper) selected(entry int64, counts []int64) bool {
+	ok := d.all || (d.every > 0 && entry%d.every == 0)
+	for i, r := range d.entries {
+		if entry >= r[0] && (r[1] < 0 || entry < r[1]) {
+			counts[i]++
+			ok = true
+		}
+	}
+	return ok
+}
+
+// Warn about the requested entries which were not processed
+func (d *dumper) warnMissing(res []*fileResult) {
+	for i, r := range d.entries {
+		var n int64
+		for _, fr := range res {
+			n += fr.dumped[i]
+		}
+		switch {
+		case r[1] == r[0]+1 && n == 0:
+			log.Printf("dumped entry %d is outside of the processed entries", r[0])
+		case r[1] < 0 && n == 0:
+			log.Printf("dumped entries %d: are outside of the processed entries", r[0])
+		case r[1] >= 0 && n < r[1]-r[0]:
+			log.Printf("%d of the dumped entries %d:%d are outside of the processed entries", r[1]-r[0]-n, r[0], r[1])
+		}
+	}
+}
+
+// Temporary file of the events dumped while processing into fnameOut
+func dumpName(fnameOut string) string {
+	return strings.TrimSuffix(fnameOut, ".root") + "_dump.txt"
+}
+
+// Write the event at the entry of the chain, read from the file fname
+func (d *dumper) dump(w io.Writer, fname string, entry int64, e *Event) error {
+	switch d.format {
+	case "json":
+		return dumpJSON(w, fname, entry, e)
+	default:
+		return dumpTable(w, fname, entry, e)
+	}
+}
+
+// Write the events dumped in each file, in the order of the input
+// files, to fname or to the standard output. The temporary files of
+// the dumped events are removed.
+func writeDumps(res []*fileResult, fname string) error {
+	if fname == "" {
+		return writeDumpsTo(os.Stdout, res)
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = writeDumpsTo(f, res)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func writeDumpsTo(w io.Writer, res []*fileResult) error {
+	for _, r := range res {
+		f, err := os.Open(r.dumps)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		os.Remove(r.dumps)
+	}
+	return nil
+}
+
+// Aligned table of the particles of the event, with their energy
+func dumpTable(w io.Writer, fname string, entry int64, e *Event) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Entry %d of %s (weight %g)\n", entry, fname, e.w)
+	fmt.Fprintf(&sb, "  %-8s %6s %10s %10s %10s %10s %10s\n", "particle", "pid", "pT", "eta", "phi", "m", "E")
+	for _, p := range e.particles() {
+		fmt.Fprintf(&sb, "  %-8s %6d %10.3f %10.4f %10.4f %10.3f %10.3f\n",
+			p.name, p.part.pid, p.part.pt, p.part.eta, p.part.phi, p.part.m, energy(*p.part))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func energy(part Particle) float64 {
+	p := get4Vec(part)
+	return p.E()
+}
+
+// Kinematics of a particle in the JSON dumps
+type particleJSON struct {
+	Pid int32   `json:"pid"`
+	Pt  float32 `json:"pt"`
+	Eta float32 `json:"eta"`
+	Phi float32 `json:"phi"`
+	M   float32 `json:"m"`
+}
+
+// Event in the JSON dumps, one per line
+type eventJSON struct {
+	File  string       `json:"file"`
+	Entry int64        `json:"entry"`
+	W     float64      `json:"w"`
+	T     particleJSON `json:"t"`
+	Tbar  particleJSON `json:"tbar"`
+	B     particleJSON `json:"b"`
+	Bbar  particleJSON `json:"bbar"`
+	WB    particleJSON `json:"W"`
+	WBbar particleJSON `json:"Wbar"`
+	L     particleJSON `json:"l"`
+	Lbar  particleJSON `json:"lbar"`
+	V     particleJSON `json:"v"`
+	Vbar  particleJSON `json:"vbar"`
+}
+
+func dumpJSON(w io.Writer, fname string, entry int64, e *Event) error {
+	p := func(part Particle) particleJSON {
+		return particleJSON{Pid: part.pid, Pt: part.pt, Eta: part.eta, Phi: part.phi, M: part.m}
+	}
+	return json.NewEncoder(w).Encode(eventJSON{
+		File:  fname,
+		Entry: entry,
+		W:     e.w,
+		T:     p(e.t),
+		Tbar:  p(e.tbar),
+		B:     p(e.b),
+		Bbar:  p(e.bbar),
+		WB:    p(e.W),
+		WBbar: p(e.Wbar),
+		L:     p(e.l),
+		Lbar:  p(e.lbar),
+		V:     p(e.v),
+		Vbar:  p(e.vbar),
+	})
+}
@@ -0,0 +1,181 @@
+// Entanglement and Bell-inequality observables, inclusive and in bins
+// of the ttbar invariant mass and of the top velocity
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go-hep.org/x/hep/fmom"
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/spin"
+)
+
+// Entanglement observables over the full phase space and in bins of
+// m_tt and of the top velocity beta in the ttbar rest frame
+type entanglement struct {
+	incl spin.Entanglement
+	bins []*entBinning
+}
+
+// Entanglement observables in the bins of an axis
+type entBinning struct {
+	axis
+	ents []spin.Entanglement
+}
+
+func newEntanglement(axes ...axis) *entanglement {
+	en := &entanglement{}
+	for _, a := range axes {
+		en.bins = append(en.bins, &entBinning{axis: a, ents: make([]spin.Entanglement, len(a.edges)-1)})
+	}
+	return en
+}
+
+// Fill the observables with the cosines of an event
+func (en *entanglement) fill(t, tbar fmom.PxPyPzE, c spin.Cosines, w float64) {
+	if !en.incl.Fill(c, w) {
+		return
+	}
+	for _, b := range en.bins {
+		if i := b.bin(t, tbar); i >= 0 {
+			b.ents[i].Fill(c, w)
+		}
+	}
+}
+
+func (en *entanglement) merge(o *entanglement) {
+	en.incl.Merge(&o.incl)
+	for k, b := range en.bins {
+		for i := range b.ents {
+			b.ents[i].Merge(&o.bins[k].ents[i])
+		}
+	}
+}
+
+// Print the entanglement observables and store them in a CSV file, with
+// plots of D, m12 and CHSH in the m_tt and beta bins in <odir>/entanglement
+func reportEntanglement(en *entanglement, fname, odir string) error {
+	res := en.incl.Result()
+	fmt.Printf(" --> Entanglement (%d events with undefined cosines skipped)\n", en.incl.Skipped)
+	fmt.Printf("     %-18s %8s %18s %18s %18s\n", "bin", "events", "D", "m12", "CHSH")
+	printEntanglement("inclusive", res)
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"variable", "low", "high", "events", "D", "D_err", "m12", "m12_err", "CHSH", "CHSH_err", "entangled", "bell_violation"})
+	writeEntanglement(w, "inclusive", math.Inf(-1), math.Inf(+1), res)
+	for _, b := range en.bins {
+		for i := range b.ents {
+			var (
+				lo, hi = b.edges[i], b.edges[i+1]
+				r      = b.ents[i].Result()
+			)
+			printEntanglement(fmt.Sprintf("%s [%g, %g[", b.name, lo, hi), r)
+			writeEntanglement(w, b.name, lo, hi, r)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Join(odir, "entanglement"), 0755)
+	if err != nil {
+		return err
+	}
+	for _, b := range en.bins {
+		for _, obs := range []struct {
+			name  string
+			get   func(r spin.EntanglementResult) spin.Result
+			limit float64 // entanglement or Bell violation limit
+		}{
+			{"D", func(r spin.EntanglementResult) spin.Result { return r.D }, -1.0 / 3},
+			{"m12", func(r spin.EntanglementResult) spin.Result { return r.M12 }, 1},
+			{"CHSH", func(r spin.EntanglementResult) spin.Result { return r.CHSH }, 2},
+		} {
+			var pts []hbook.Point2D
+			for i := range b.ents {
+				r := obs.get(b.ents[i].Result())
+				if math.IsNaN(r.Value) {
+					continue
+				}
+				lo, hi := b.edges[i], b.edges[i+1]
+				pts = append(pts, hbook.Point2D{
+					X:    0.5 * (lo + hi),
+					Y:    r.Value,
+					ErrX: hbook.Range{Min: 0.5 * (hi - lo), Max: 0.5 * (hi - lo)},
+					ErrY: hbook.Range{Min: r.Error, Max: r.Error},
+				})
+			}
+			if len(pts) == 0 {
+				continue
+			}
+
+			p := hplot.New()
+			p.Title.Text = fmt.Sprintf("%s vs %s (inclusive: %.3f +/- %.3f)", obs.name, b.name, obs.get(res).Value, obs.get(res).Error)
+			p.X.Label.Text = b.label
+			p.Y.Label.Text = obs.name
+			s := hplot.NewS2D(hbook.NewS2D(pts...), hplot.WithXErrBars(true), hplot.WithYErrBars(true))
+			p.Add(s)
+			limit := obs.limit
+			l := hplot.NewFunction(func(float64) float64 { return limit })
+			l.LineStyle.Color = color.RGBA{R: 255, A: 255}
+			l.LineStyle.Dashes = []vg.Length{vg.Points(4), vg.Points(2)}
+			p.Add(l)
+			p.Legend.Add("limit", l)
+			p.Legend.Top = true
+			p.Add(hplot.NewGrid())
+			s.GlyphStyle.Shape = draw.CircleGlyph{}
+
+			fname := filepath.Join(odir, "entanglement", obs.name+"_vs_"+b.name+".pdf")
+			err := p.Save(10*vg.Centimeter, 8*vg.Centimeter, fname)
+			if err != nil {
+				return fmt.Errorf("could not save plot %q: %w", fname, err)
+			}
+		}
+	}
+	return nil
+}
+
+func printEntanglement(bin string, r spin.EntanglementResult) {
+	var flags []string
+	if r.Entangled() {
+		flags = append(flags, "entangled")
+	}
+	if r.BellViolation() {
+		flags = append(flags, "Bell violation")
+	}
+	fmt.Printf("     %-18s %8d %+8.3f +/- %.3f %+8.3f +/- %.3f %+8.3f +/- %.3f  %s\n",
+		bin, r.Events, r.D.Value, r.D.Error, r.M12.Value, r.M12.Error, r.CHSH.Value, r.CHSH.Error, strings.Join(flags, ", "))
+}
+
+func writeEntanglement(w *csv.Writer, name string, lo, hi float64, r spin.EntanglementResult) {
+	format := func(x float64) string { return strconv.FormatFloat(x, 'g', -1, 64) }
+	w.Write([]string{
+		name, format(lo), format(hi), strconv.Itoa(r.Events),
+		format(r.D.Value), format(r.D.Error),
+		format(r.M12.Value), format(r.M12.Error),
+		format(r.CHSH.Value), format(r.CHSH.Error),
+		strconv.FormatBool(r.Entangled()), strconv.FormatBool(r.BellViolation()),
+	})
+}
@@ -0,0 +1,241 @@
+// Histograms of the spin observables, stored in the output ROOT file and plotted
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"go-hep.org/x/hep/fmom"
+	"go-hep.org/x/hep/groot/rhist"
+	"go-hep.org/x/hep/groot/riofs"
+	"go-hep.org/x/hep/groot/root"
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/gonum/spatial/r3"
+	"gonum.org/v1/plot/vg"
+
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/reco"
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/spin"
+)
+
+// Number of bins of the cosine distributions
+const nbins = 20
+
+// Histogram with its directory and axis title
+type histo struct {
+	dir    string
+	xlabel string
+	ylabel string
+	h1     *hbook.H1D
+	h2     *hbook.H2D
+}
+
+// Booked histograms, filled for each event
+type histos struct {
+	list []*histo
+
+	cos   [7]*hbook.H1D    // cosines: k+, r+, n+, k-, r-, n-, phi
+	prod  [3][3]*hbook.H1D // products cos(i+) cos(j-)
+	corr  [3][3]*hbook.H2D // cos(i+) vs cos(j-)
+	basis [3][3]*hbook.H1D // components of k, r, n
+
+	// Resolution of the reconstructed observables
+	reco bool
+	dcos [6]*hbook.H1D // reco - truth cosines
+	dpt  [2]*hbook.H1D // (reco - truth)/truth pT of the top and anti-top
+	dmtt *hbook.H1D    // (reco - truth)/truth ttbar mass
+}
+
+// Names of the cosines in the order of the reconstruction histograms
+var recoCosNames = [6]string{"cosO_kp", "cosO_rp", "cosO_np", "cosO_km", "cosO_rm", "cosO_nm"}
+
+func newHistos(reco bool) *histos {
+	hs := &histos{reco: reco}
+	bookRange := func(dir, name, xlabel string, n int, xmin, xmax float64) *hbook.H1D {
+		h := hbook.NewH1D(n, xmin, xmax)
+		h.Ann["name"] = name
+		hs.list = append(hs.list, &histo{dir: dir, xlabel: xlabel, ylabel: "Events", h1: h})
+		return h
+	}
+	book1 := func(dir, name, xlabel string) *hbook.H1D {
+		return bookRange(dir, name, xlabel, nbins, -1, 1)
+	}
+	book2 := func(dir, name, xlabel, ylabel string) *hbook.H2D {
+		h := hbook.NewH2D(nbins, -1, 1, nbins, -1, 1)
+		h.Ann["name"] = name
+		hs.list = append(hs.list, &histo{dir: dir, xlabel: xlabel, ylabel: ylabel, h2: h})
+		return h
+	}
+
+	for i, a := range spin.Axes {
+		hs.cos[i] = book1("cosines", "cosO_"+a+"p", "cos(theta_"+a+"+)")
+		hs.cos[i+3] = book1("cosines", "cosO_"+a+"m", "cos(theta_"+a+"-)")
+	}
+	hs.cos[6] = book1("cosines", "cos_phi", "cos(phi)")
+
+	for i, a := range spin.Axes {
+		for j, b := range spin.Axes {
+			name := "cosO_" + a + "p_" + b + "m"
+			xlabel := "cos(theta_" + a + "+)"
+			ylabel := "cos(theta_" + b + "-)"
+			hs.prod[i][j] = book1("products", name, xlabel+" x "+ylabel)
+			hs.corr[i][j] = book2("correlations", name, xlabel, ylabel)
+		}
+	}
+
+	for i, a := range spin.Axes {
+		for j, c := range []string{"x", "y", "z"} {
+			hs.basis[i][j] = book1("basis", a+"_"+c, a+"_"+c)
+		}
+	}
+
+	if reco {
+		for i, c := range recoCosNames {
+			hs.dcos[i] = bookRange("resolution", c, c+" (reco - truth)", 2*nbins, -2, 2)
+		}
+		hs.dpt[0] = bookRange("resolution", "t_pt", "t pT (reco - truth)/truth", 2*nbins, -1, 1)
+		hs.dpt[1] = bookRange("resolution", "tbar_pt", "tbar pT (reco - truth)/truth", 2*nbins, -1, 1)
+		hs.dmtt = bookRange("resolution", "mtt", "ttbar mass (reco - truth)/truth", 2*nbins, -1, 1)
+	}
+
+	return hs
+}
+
+// Fill the histograms with the cosines and the basis vectors of an event
+func (hs *histos) fill(c spin.Cosines, k, r, n r3.Vec, w float64) {
+	var (
+		p = [3]float64{c.KP, c.RP, c.NP}
+		m = [3]float64{c.KM, c.RM, c.NM}
+	)
+	for i := range p {
+		hs.cos[i].Fill(p[i], w)
+		hs.cos[i+3].Fill(m[i], w)
+		for j := range m {
+			hs.prod[i][j].Fill(p[i]*m[j], w)
+			hs.corr[i][j].Fill(p[i], m[j], w)
+		}
+	}
+	hs.cos[6].Fill(c.Dphi, w)
+
+	for i, v := range []r3.Vec{k, r, n} {
+		for j, x := range []float64{v.X, v.Y, v.Z} {
+			hs.basis[i][j].Fill(x, w)
+		}
+	}
+}
+
+// Fill the resolution histograms, comparing the reconstructed and truth observables
+func (hs *histos) fillReco(truth, rec spin.Cosines, t, tbar fmom.PxPyPzE, sol reco.Solution, w float64) {
+	var (
+		ct = [6]float64{truth.KP, truth.RP, truth.NP, truth.KM, truth.RM, truth.NM}
+		cr = [6]float64{rec.KP, rec.RP, rec.NP, rec.KM, rec.RM, rec.NM}
+	)
+	for i := range ct {
+		if !math.IsNaN(ct[i]) && !math.IsNaN(cr[i]) {
+			hs.dcos[i].Fill(cr[i]-ct[i], w)
+		}
+	}
+
+	relDiff := func(rec, truth float64) float64 { return (rec - truth) / truth }
+	if t.Pt() > 0 {
+		hs.dpt[0].Fill(relDiff(sol.Top.Pt(), t.Pt()), w)
+	}
+	if tbar.Pt() > 0 {
+		hs.dpt[1].Fill(relDiff(sol.AntiTop.Pt(), tbar.Pt()), w)
+	}
+	if t.Pt() > 0 && tbar.Pt() > 0 {
+		mtt := fmom.Add(&t, &tbar).M()
+		mttReco := fmom.Add(&sol.Top, &sol.AntiTop).M()
+		hs.dmtt.Fill(relDiff(mttReco, mtt), w)
+	}
+}
+
+// Set the i-th bin of h to the value v with the uncertainty err, as
+// a single entry of weight v, so that the statistics of the bins and
+// of the whole histogram stay consistent (e.g. to store coefficients
+// measured in bins)
+func setBin(h *hbook.H1D, i int, v, err float64) {
+	var (
+		bin = &h.Binning.Bins[i]
+		x   = bin.XMid()
+	)
+	bin.Dist.Dist = hbook.Dist0D{N: 1, SumW: v, SumW2: err * err}
+	bin.Dist.Stats.SumWX = v * x
+	bin.Dist.Stats.SumWX2 = v * x * x
+
+	tot := &h.Binning.Dist
+	*tot = hbook.Dist1D{}
+	for _, b := range h.Binning.Bins {
+		tot.Dist.N += b.Dist.Dist.N
+		tot.Dist.SumW += b.Dist.Dist.SumW
+		tot.Dist.SumW2 += b.Dist.Dist.SumW2
+		tot.Stats.SumWX += b.Dist.Stats.SumWX
+		tot.Stats.SumWX2 += b.Dist.Stats.SumWX2
+	}
+}
+
+// Save the histograms in their directory of the ROOT file
+func (hs *histos) write(f *riofs.File) error {
+	dirs := make(map[string]riofs.Directory)
+	for _, h := range hs.list {
+		dir, ok := dirs[h.dir]
+		if !ok {
+			var err error
+			dir, err = riofs.Dir(f).Mkdir(h.dir)
+			if err != nil {
+				return fmt.Errorf("could not create directory %q: %w", h.dir, err)
+			}
+			dirs[h.dir] = dir
+		}
+
+		var (
+			obj  root.Object
+			name string
+		)
+		switch {
+		case h.h1 != nil:
+			obj, name = rhist.NewH1DFrom(h.h1), h.h1.Name()
+		default:
+			obj, name = rhist.NewH2DFrom(h.h2), h.h2.Name()
+		}
+		err := dir.Put(name, obj)
+		if err != nil {
+			return fmt.Errorf("could not save histogram %s/%s: %w", h.dir, name, err)
+		}
+	}
+	return nil
+}
+
+// Plot each histogram into <odir>/<directory>/<name>.pdf
+func (hs *histos) plot(odir string) error {
+	for _, h := range hs.list {
+		err := os.MkdirAll(filepath.Join(odir, h.dir), 0755)
+		if err != nil {
+			return err
+		}
+
+		p := hplot.New()
+		p.X.Label.Text = h.xlabel
+		p.Y.Label.Text = h.ylabel
+		var name string
+		switch {
+		case h.h1 != nil:
+			name = h.h1.Name()
+			p.Add(hplot.NewH1D(h.h1, hplot.WithYErrBars(true), hplot.WithHInfo(hplot.HInfoSummary)))
+		default:
+			name = h.h2.Name()
+			p.Add(hplot.NewH2D(h.h2, nil))
+		}
+		p.Title.Text = name
+		p.Add(hplot.NewGrid())
+
+		fname := filepath.Join(odir, h.dir, name+".pdf")
+		err = p.Save(10*vg.Centimeter, 8*vg.Centimeter, fname)
+		if err != nil {
+			return fmt.Errorf("could not save plot %q: %w", fname, err)
+		}
+	}
+	return nil
+}
@@ -2,20 +2,29 @@
 package main
 
 import (
+	"bufio"
+	"encoding/csv"
 	"flag"
 	"fmt"
 	"log"
 	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
 	"strings"
 
 	"go-hep.org/x/hep/groot"
 	"go-hep.org/x/hep/groot/rtree"
 
-	"gonum.org/v1/gonum/spatial/r3"
 	"go-hep.org/x/hep/fmom"
+
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/reco"
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/spin"
 )
 
 type Event struct {
+	w       float64
 	t, tbar Particle
 	b, bbar Particle
 	W, Wbar Particle
@@ -44,46 +53,276 @@ type SpinObservables struct {
 	cos_np  float64
 }
 
+// Observables computed with the reconstructed tops
+type RecoObservables struct {
+	ok      int32
+	w       float64
+	t_pt    float64
+	tbar_pt float64
+	cos     [6]float64 // cosO_kp, cosO_rp, cosO_np, cosO_km, cosO_rm, cosO_nm
+}
+
 func main() {
 
 	var (
-		fname   = flag.String("f", "ttbar_0j_parton.root", "path to ROOT file to analyze")
-		tname   = flag.String("t", "truth", "ROOT Tree name to analyze")
-		evtmax  = flag.Int64("n", 10000, "number of events to analyze")
-		verbose = flag.Bool("v", false, "verbose mode")
+		fname    = flag.String("f", "ttbar_0j_parton.root", "comma separated list or glob of ROOT files to analyze")
+		tname    = flag.String("t", "truth", "ROOT Tree name to analyze")
+		ofname   = flag.String("o", "", "output ROOT file (default: <input>_processed.root, required for several inputs)")
+		evtmax   = flag.Int64("n", 10000, "number of entries to read from the first one (-1: all)")
+		first    = flag.Int64("first", 0, "first entry to read (entries are numbered along the chain of input files)")
+		entries  = flag.String("range", "", "range beg:end of entries to read, end excluded (overrides -first and -n)")
+		prescale = flag.Int64("prescale", 1, "keep one entry out of prescale")
+		frac     = flag.Float64("sample", 1, "randomly keep this fraction of the entries")
+		verbose  = flag.Bool("v", false, "verbose mode (dump one entry out of 100)")
+		dumpFmt  = flag.String("dump", "", "dump the events: table or json (one line per event)")
+		dumpEvts = flag.String("dumpentries", "", "comma separated entries or ranges beg:end of the chain to dump, among the processed ones (default: all)")
+		dumpOut  = flag.String("dumpfile", "", "file to write the dumped events to (default: standard output)")
+		basis    = flag.String("basis", "helicity", "spin basis: helicity or beam")
+		nworkers = flag.Int("j", runtime.NumCPU(), "number of files processed concurrently")
+		doReco   = flag.Bool("reco", false, "also reconstruct the tops from leptons, b-quarks and MET (neutrino weighting)")
+		detector = flag.String("smear", "", "smear leptons, b-quarks and MET before the reconstruction: default or detector JSON file")
+		seed     = flag.Int64("seed", 1, "seed of the smearing (incremented for each input file) and sampling random numbers")
+		doUnfold = flag.Bool("unfold", false, "unfold the reconstructed cosines (closure test on independent halves of the events)")
+		niter    = flag.Int("niter", 4, "number of iterations of the D'Agostini unfolding")
+		tau      = flag.Float64("tau", 0, "Tikhonov regularisation strength of the matrix inversion unfolding (0: plain inversion)")
+		branches = flag.String("branches", defaultBranches, "comma separated list or globs of input branches copied to the output tree")
+		reweight = flag.String("reweight", "", "comma separated list of spin hypotheses to reweight to: nospin or JSON files")
+		nominal  = flag.String("nominal", "", "JSON file of the nominal spin state of the sample (default: measured in a first pass)")
+		aliases  = flag.String("aliases", "", "file of derived variables stored in the output tree, one name=expression per line")
+		doWHel   = flag.Bool("whel", false, "measure the W-helicity fractions from the cos(theta*) of the leptons")
+		doEnt    = flag.Bool("entanglement", false, "measure the entanglement marker D and the CHSH Bell observables")
+		mttBins  = flag.String("mttbins", "300,400,500,600,800,1200,3000", "comma separated m_tt bin edges [GeV] of the entanglement observables")
+		betaBins = flag.String("betabins", "0,0.2,0.4,0.6,0.8,0.9,1", "comma separated top velocity bin edges of the entanglement observables")
+		aliasDefs []string
 	)
+	flag.Func("alias", "derived variable stored in the output tree, e.g. 'mtt=M(t+tbar)' (repeatable)", func(s string) error {
+		aliasDefs = append(aliasDefs, s)
+		return nil
+	})
+	var diffAxes []axis
+	flag.Func("diff", "measure the spin coefficients in bins of mtt, pt_t, absy_tt, cos_theta or beta, e.g. 'mtt' or 'mtt=300,400,600,3000' (repeatable)", func(s string) error {
+		a, err := parseAxis(s)
+		if err != nil {
+			return err
+		}
+		diffAxes = append(diffAxes, a)
+		return nil
+	})
 
 	flag.Parse()
 
-	eventLoop(*fname, *tname, *evtmax, *verbose)
+	frame, err := spin.ParseFrame(*basis)
+	if err != nil {
+		log.Fatalf("invalid spin basis: %+v", err)
+	}
+	cfg := config{
+		tname:    *tname,
+		sample:   sampling{first: *first, n: *evtmax, prescale: *prescale, frac: *frac, seed: *seed},
+		frame:    frame,
+		reco:     *doReco || *doUnfold,
+		seed:     *seed,
+		unfold:   *doUnfold,
+		niter:    *niter,
+		tau:      *tau,
+		branches: *branches,
+		whel:     *doWHel,
+	}
+	if *entries != "" {
+		cfg.sample.first, cfg.sample.n, err = parseRange(*entries)
+		if err != nil {
+			log.Fatalf("invalid options: %+v", err)
+		}
+	}
+	err = cfg.sample.validate()
+	if err != nil {
+		log.Fatalf("invalid options: %+v", err)
+	}
+	cfg.aliases, err = parseAliases(aliasDefs, *aliases)
+	if err != nil {
+		log.Fatalf("invalid aliases: %+v", err)
+	}
+	cfg.dump, err = newDumper(*dumpFmt, *dumpEvts, *verbose)
+	if err != nil {
+		log.Fatalf("invalid options: %+v", err)
+	}
+	if *doEnt {
+		for _, s := range []string{"mtt=" + *mttBins, "beta=" + *betaBins} {
+			a, err := parseAxis(s)
+			if err != nil {
+				log.Fatalf("invalid options: %+v", err)
+			}
+			cfg.entAxes = append(cfg.entAxes, a)
+		}
+	}
+	cfg.diffAxes = diffAxes
+	if *detector != "" {
+		cfg.det, err = readDetector(*detector)
+		if err != nil {
+			log.Fatalf("invalid detector: %+v", err)
+		}
+		cfg.reco = true
+	}
+
+	fnames, err := expandInputs(*fname)
+	if err != nil {
+		log.Fatalf("invalid input files: %+v", err)
+	}
+	fnameOut := *ofname
+	switch {
+	case fnameOut != "":
+	case len(fnames) == 1:
+		fnameOut = strings.ReplaceAll(fnames[0], ".root", "_processed.root")
+	default:
+		log.Fatalf("an output file (-o) is needed to process %d input files", len(fnames))
+	}
+
+	// Spin hypotheses of the reweighting, with respect to the nominal spin state
+	if *reweight != "" {
+		vs, err := parseVariations(*reweight)
+		if err != nil {
+			log.Fatalf("invalid spin hypotheses: %+v", err)
+		}
+		cfg.rw = &reweighting{}
+		switch *nominal {
+		case "":
+			fmt.Println("Measuring the nominal spin state")
+			cfg.rw.split = true
+			cfg.rw.nominal, err = measureNominal(fnames, cfg)
+			if err != nil {
+				log.Fatalf("could not measure the nominal spin state: %+v", err)
+			}
+		default:
+			cfg.rw.nominal, err = readNominal(*nominal)
+			if err != nil {
+				log.Fatalf("invalid nominal spin state: %+v", err)
+			}
+		}
+		for _, v := range vs {
+			cfg.rw.hyps = append(cfg.rw.hyps, v.Apply(cfg.rw.nominal))
+		}
+	}
+
+	// Process the files concurrently, then merge their outputs in the input order
+	res, err := processFiles(fnames, fnameOut, cfg, *nworkers)
+	if err != nil {
+		log.Fatalf("could not process the input files: %+v", err)
+	}
+	if cfg.dump != nil {
+		err = writeDumps(res, *dumpOut)
+		if err != nil {
+			removeParts(fnameOut, len(res))
+			log.Fatalf("could not write the dumped events: %+v", err)
+		}
+		cfg.dump.warnMissing(res)
+	}
+	tot, err := mergeResults(res, fnameOut, cfg)
+	if err != nil {
+		log.Fatalf("could not merge the outputs: %+v", err)
+	}
+
+	// Report and results
+	fmt.Printf(" --> Event loop is done: %d events processed (%d entries read) and stored in %s\n", tot.nEvt, tot.nRead, fnameOut)
+	if len(res) > 1 {
+		for _, r := range res {
+			fmt.Printf("     %-40s: %d events (%d entries read)\n", r.fname, r.nEvt, r.nRead)
+		}
+	}
+	report(tot, cfg, strings.TrimSuffix(strings.TrimSuffix(fnameOut, ".root"), "_processed"))
+}
+
+// Options of the event loop
+type config struct {
+	tname    string
+	sample   sampling // processed entries of the chain
+	offset   int64    // index of the first entry of the file in the chain
+	frame    spin.Frame
+	reco     bool      // reconstruct the tops from the leptons, b-quarks and MET
+	det      *Detector // smearing before the reconstruction, if any
+	seed     int64
+	unfold   bool    // unfold the reconstructed cosines
+	niter    int     // iterations of the D'Agostini unfolding
+	tau      float64 // regularisation of the matrix inversion unfolding
+	branches string  // input branches copied to the output tree
+	aliases  []alias // derived variables stored in the output tree
+	rw       *reweighting // spin hypotheses of the reweighting, if any
+	whel     bool         // measure the W-helicity fractions
+	entAxes  []axis       // binning of the entanglement observables, if measured
+	diffAxes []axis       // binning of the differential spin coefficients, if any
+	dump     *dumper      // dumped events, if any
+}
+
+// Outcome of the event loop over one file
+type fileResult struct {
+	fname     string
+	nRead     int64 // entries read
+	nEvt      int64 // entries kept by the sampling and processed
+	nFallback int
+	nRecoMiss int // missing (lost or untagged) particles
+	nRecoFail int // no solution of the reconstruction
+	coeffs    spin.Estimator
+	hs        *histos
+	unf       *unfolding // responses of the cosines, if unfolded
+	unfolded  []unfolded
+	rwCoeffs  []spin.Estimator // coefficients of the reweighted events
+	nRwBad    int              // events with non positive weights
+	ent       *entanglement    // entanglement observables, if measured
+	dumps     string           // temporary file of the dumped events, if any
+	dumped    []int64          // dumped entries of each requested range
+	diff      *differential    // differential spin coefficients, if measured
+	whel      *spin.WHelicity  // cos(theta*) of the leptons, if measured
 }
 
-// Event loop
-func eventLoop(fname string, tname string, evtmax int64, verbose bool) {
+// Event loop over the file fname, storing the new variables in fnameOut.
+// On failure, the output file is removed.
+func eventLoop(fname, fnameOut string, cfg config) (res *fileResult, err error) {
+
+	var (
+		tname = cfg.tname
+		frame = cfg.frame
+	)
 
 	// Open the root file and get the tree
 	fmt.Println("Processing the TTree", tname, "in the ROOT file", fname)
-	file := openRootFile(fname)
-	tree := getTtree(file, tname)
+	file, tree, err := openTree(fname, tname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 
 	// Create a scanner to perform the event loop on the input tree
 	var (
 		e Event
 		rvars = getReadVariables(&e)
 	)
-	r, err := rtree.NewReader(tree, rvars)
+	e.w = 1
+	if tree.Branch("w_xec") != nil {
+		rvars = append(rvars, rtree.ReadVar{Name: "w_xec", Value: &e.w})
+	}
+
+	// Input branches copied to the output, read along with the event
+	copied, err := copiedBranches(tree, cfg.branches, &rvars)
+	if err != nil {
+		return nil, fmt.Errorf("could not select the copied branches of %q: %w", fname, err)
+	}
+	beg, end := cfg.sample.fileRange(cfg.offset, tree.Entries())
+	r, err := rtree.NewReader(tree, rvars, rtree.WithRange(beg, end))
 	if err != nil {
-		log.Fatalf("could not create tree reader: %+v", err)
+		return nil, fmt.Errorf("could not create tree reader of %q: %w", fname, err)
 	}
 	defer r.Close()
 	
 	// Ceate a new file, new writer to save new variables in a tree
-	fnameOut := strings.ReplaceAll(fname, ".root", "_processed.root")
 	fout, err := groot.Create(fnameOut)
 	if err != nil {
-		log.Fatalf("could not create ROOT file %q: %w", fnameOut, err)
+		return nil, fmt.Errorf("could not create ROOT file %q: %w", fnameOut, err)
 	}
 	defer fout.Close()
+	defer func() {
+		if err != nil {
+			fout.Close()
+			os.Remove(fnameOut)
+		}
+	}()
 	var spin_var SpinObservables
 	wvars := []rtree.WriteVar{
 		{Name: "kvec"   , Value: &spin_var.kVec},
@@ -96,31 +335,141 @@ func eventLoop(fname string, tname string, evtmax int64, verbose bool) {
 		{Name: "cosO_nm", Value: &spin_var.cos_nm},
 		{Name: "cosO_np", Value: &spin_var.cos_np},
 		{Name: "dphi_ll", Value: &spin_var.dphi_ll},
+	}
+	wvars = append(wvars, copied...)
+	var (
+		reco_var  RecoObservables
+		smear_var Event
+		metX      float64
+		metY      float64
+		rng       = rand.New(rand.NewSource(cfg.seed))
+	)
+	if cfg.reco {
+		wvars = append(wvars, []rtree.WriteVar{
+			{Name: "reco_ok", Value: &reco_var.ok},
+			{Name: "reco_w", Value: &reco_var.w},
+			{Name: "reco_t_pt", Value: &reco_var.t_pt},
+			{Name: "reco_tbar_pt", Value: &reco_var.tbar_pt},
+		}...)
+		for i, c := range recoCosNames {
+			wvars = append(wvars, rtree.WriteVar{Name: "reco_" + c, Value: &reco_var.cos[i]})
+		}
+	}
+	if cfg.det != nil {
+		for _, p := range []struct {
+			name string
+			part *Particle
+		}{{"l", &smear_var.l}, {"lbar", &smear_var.lbar}, {"b", &smear_var.b}, {"bbar", &smear_var.bbar}} {
+			wvars = append(wvars, []rtree.WriteVar{
+				{Name: "smear_" + p.name + "_pt", Value: &p.part.pt},
+				{Name: "smear_" + p.name + "_eta", Value: &p.part.eta},
+				{Name: "smear_" + p.name + "_phi", Value: &p.part.phi},
+				{Name: "smear_" + p.name + "_pid", Value: &p.part.pid},
+			}...)
+		}
+		wvars = append(wvars, []rtree.WriteVar{
+			{Name: "smear_met_x", Value: &metX},
+			{Name: "smear_met_y", Value: &metY},
+		}...)
+	}
+	var cosStar [2]float64 // top, anti-top
+	if cfg.whel {
+		wvars = append(wvars, []rtree.WriteVar{
+			{Name: "cos_thetastar_t", Value: &cosStar[0]},
+			{Name: "cos_thetastar_tbar", Value: &cosStar[1]},
+		}...)
+	}
+	var rw_var []float64
+	if cfg.rw != nil {
+		rw_var = make([]float64, len(cfg.rw.hyps))
+		for k, h := range cfg.rw.hyps {
+			wvars = append(wvars, rtree.WriteVar{Name: "rw_" + h.Name, Value: &rw_var[k]})
+		}
+	}
 
-		{Name: "t_pt"   , Value: &e.t.pt},
-		{Name: "tbar_pt", Value: &e.tbar.pt},
-		{Name: "t_pid"   , Value: &e.t.pid},
-		{Name: "tbar_pid", Value: &e.tbar.pid},
-		{Name: "l_pid"   , Value: &e.l.pid},
-		{Name: "lbar_pid", Value: &e.lbar.pid},
-
+	// Derived variables, evaluated once the event is complete
+	aliases, err := compileAliases(cfg.aliases, &e, scalarVars(rvars, wvars))
+	if err != nil {
+		return nil, fmt.Errorf("could not compile the aliases: %w", err)
+	}
+	for _, a := range aliases {
+		wvars = append(wvars, rtree.WriteVar{Name: a.name, Value: &a.value})
+	}
+	err = checkNames(wvars)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output tree: %w", err)
 	}
 	tout, err := rtree.NewWriter(fout, tname, wvars)
 	if err != nil {
-		log.Fatalf("could not create scanner: %+v", err)
+		return nil, fmt.Errorf("could not create tree-writer of %q: %w", fnameOut, err)
 	}
 	defer tout.Close()
 
+	// Spin-density matrix coefficients, histograms and number
+	// of events with the top along the beam (fallback basis)
+	res = &fileResult{fname: fname, hs: newHistos(cfg.reco)}
+	if cfg.unfold {
+		res.unf = newUnfolding()
+	}
+	if cfg.rw != nil {
+		res.rwCoeffs = make([]spin.Estimator, len(cfg.rw.hyps))
+	}
+	if cfg.entAxes != nil {
+		res.ent = newEntanglement(cfg.entAxes...)
+	}
+	if cfg.diffAxes != nil {
+		res.diff = newDifferential(cfg.diffAxes)
+	}
+	if cfg.whel {
+		res.whel = spin.NewWHelicity(nbins)
+	}
+
+	// Dumped events, streamed to a temporary file
+	var dumps *bufio.Writer
+	if cfg.dump != nil {
+		res.dumps = dumpName(fnameOut)
+		res.dumped = make([]int64, len(cfg.dump.entries))
+		var fdump *os.File
+		fdump, err = os.Create(res.dumps)
+		if err != nil {
+			return nil, fmt.Errorf("could not create dump file: %w", err)
+		}
+		defer fdump.Close()
+		defer func() {
+			if err != nil {
+				fdump.Close()
+				os.Remove(res.dumps)
+			}
+		}()
+		dumps = bufio.NewWriter(fdump)
+		defer func() {
+			if err == nil {
+				err = dumps.Flush()
+			}
+			if err == nil {
+				err = fdump.Close()
+			}
+		}()
+	}
+
 	// Actual event loop
 	err = r.Read(func(ctx rtree.RCtx) error {
 
 		// Entry index
 		ievt := ctx.Entry
 
-		// Print the partonic event
-		if ievt%100 == 0 && verbose {
-			fmt.Println("\nEvent", ievt)
-			printEvent(e)
+		// Prescale and random sampling
+		res.nRead++
+		if !cfg.sample.keep(cfg.offset + ievt) {
+			return nil
+		}
+
+		// Dump the partonic event
+		if cfg.dump != nil && cfg.dump.selected(cfg.offset+ievt, res.dumped) {
+			err := cfg.dump.dump(dumps, fname, cfg.offset+ievt, &e)
+			if err != nil {
+				return fmt.Errorf("could not dump event %d: %w", ievt, err)
+			}
 		}
 
 		// Re-computing spin observables
@@ -133,118 +482,233 @@ func eventLoop(fname string, tname string, evtmax int64, verbose bool) {
 			lplus_P4, lminus_P4 fmom.PxPyPzE
 		)
 		// Get top/anti-top and lepton/ant-lepton four-vectors
-		get4Vec := func(part Particle) fmom.PxPyPzE {
-			var p fmom.PxPyPzE
-			p.SetPtEtaPhiM(
-				float64(part.pt),
-				float64(part.eta),
-				float64(part.phi),
-				float64(part.m),
-			)
-			return p
-		}
 		tplus_P4  = get4Vec(top)
 		tminus_P4 = get4Vec(antitop)
 		lminus_P4 = get4Vec(lep)
 		lplus_P4  = get4Vec(antilep)
 		
 		// Perform the actual computation (spin basis and angles)
-		cosTheta := computeSpinCosines(tplus_P4, tminus_P4, lplus_P4, lminus_P4)
+		cosTheta := spin.ComputeCosines(frame, tplus_P4, tminus_P4, lplus_P4, lminus_P4)
+		if cosTheta.Fallback {
+			res.nFallback++
+		}
 
 		// Save the newly computed info into a TTree
-		k, r, n := getSpinBasis(tplus_P4, tminus_P4)
+		k, r, n, _ := spin.Basis(frame, tplus_P4, tminus_P4)
 		spin_var.kVec = [3]float64{k.X, k.Y, k.Z}
 		spin_var.rVec = [3]float64{r.X, r.Y, r.Z}
 		spin_var.nVec = [3]float64{n.X, n.Y, n.Z}
-		spin_var.dphi_ll = cosTheta["dphi"]
-		spin_var.cos_km = cosTheta["k-"]
-		spin_var.cos_rm = cosTheta["r-"]
-		spin_var.cos_nm = cosTheta["n-"]
-		spin_var.cos_kp = cosTheta["k+"]
-		spin_var.cos_rp = cosTheta["r+"]
-		spin_var.cos_np = cosTheta["n+"]
-		
-		_, err = tout.Write()
+		spin_var.dphi_ll = cosTheta.Dphi
+		spin_var.cos_km = cosTheta.KM
+		spin_var.cos_rm = cosTheta.RM
+		spin_var.cos_nm = cosTheta.NM
+		spin_var.cos_kp = cosTheta.KP
+		spin_var.cos_rp = cosTheta.RP
+		spin_var.cos_np = cosTheta.NP
+		if res.coeffs.Fill(cosTheta, e.w) {
+			res.hs.fill(cosTheta, k, r, n, e.w)
+		}
+		if res.ent != nil {
+			res.ent.fill(tplus_P4, tminus_P4, cosTheta, e.w)
+		}
+		if res.diff != nil {
+			res.diff.fill(tplus_P4, tminus_P4, cosTheta, e.w)
+		}
+
+		// Lepton angles in the W rest frames, for the W-helicity fractions
+		if res.whel != nil {
+			cosStar[0] = spin.CosThetaStar(tplus_P4, get4Vec(e.W), lplus_P4)
+			cosStar[1] = spin.CosThetaStar(tminus_P4, get4Vec(e.Wbar), lminus_P4)
+			res.whel.Fill(cosStar[0], e.w)
+			res.whel.Fill(cosStar[1], e.w)
+		}
+
+		// Weights of the spin hypotheses, and their coefficients for validation
+		if cfg.rw != nil {
+			refit := cfg.rw.refitted(cfg.offset + ievt)
+			for i, h := range cfg.rw.hyps {
+				w, ok := h.Weight(cfg.rw.nominal, cosTheta)
+				if !ok && !math.IsNaN(cosTheta.KP) {
+					res.nRwBad++
+				}
+				rw_var[i] = w
+				if refit {
+					res.rwCoeffs[i].Fill(cosTheta, e.w*w)
+				}
+			}
+		}
+
+		// Reconstructed tops, from the leptons, b-quarks and the neutrinos
+		// pT sum, possibly smeared by the detector response
+		if cfg.reco {
+			reco_var = RecoObservables{}
+			in := e
+			if cfg.det != nil {
+				in, metX, metY = cfg.det.smear(e, rng)
+				smear_var = in
+			} else {
+				vP4, vbarP4 := get4Vec(e.v), get4Vec(e.vbar)
+				metX, metY = vP4.Px()+vbarP4.Px(), vP4.Py()+vbarP4.Py()
+			}
+			var (
+				lplus  = get4Vec(in.lbar)
+				lminus = get4Vec(in.l)
+				sol     reco.Solution
+				recoCos spin.Cosines
+				ok      bool
+			)
+			switch {
+			case in.l.pid == 0 || in.lbar.pid == 0 || in.b.pid == 0 || in.bbar.pid == 0:
+				res.nRecoMiss++
+			default:
+				sol, ok = reco.NeutrinoWeighting(lplus, lminus, get4Vec(in.b), get4Vec(in.bbar), metX, metY, reco.DefaultOptions)
+				if !ok {
+					res.nRecoFail++
+				}
+			}
+			if ok {
+				recoCos = spin.ComputeCosines(frame, sol.Top, sol.AntiTop, lplus, lminus)
+				reco_var.ok = 1
+				reco_var.w = sol.Weight
+				reco_var.t_pt = sol.Top.Pt()
+				reco_var.tbar_pt = sol.AntiTop.Pt()
+				reco_var.cos = [6]float64{recoCos.KP, recoCos.RP, recoCos.NP, recoCos.KM, recoCos.RM, recoCos.NM}
+				res.hs.fillReco(cosTheta, recoCos, tplus_P4, tminus_P4, sol, e.w)
+			}
+			if res.unf != nil {
+				res.unf.fill(ievt, cosTheta, recoCos, ok, e.w)
+			}
+		}
+
+		for _, a := range aliases {
+			a.value = a.eval()
+		}
+
+		_, err := tout.Write()
 		if err != nil {
-			log.Fatalf("could not write event %d: %+v", ievt, err)
+			return fmt.Errorf("could not write event %d: %w", ievt, err)
 		}
+		res.nEvt++
 			
 		return nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read tree of %q: %w", fname, err)
+	}
 		
 	err = tout.Close()
 	if err != nil {
-		log.Fatalf("could not close tree-writer: %+v", err)
+		return nil, fmt.Errorf("could not close tree-writer of %q: %w", fnameOut, err)
 	}
-	
-	fmt.Println(" --> Event loop is done:", tree.Entries(), "events processed and stored in", fnameOut)
+	err = fout.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not close ROOT file %q: %w", fnameOut, err)
+	}
+
+	return res, nil
 }
 
-// Compute spin-related cosines
-func computeSpinCosines(tplus, tminus, lplus, lminus fmom.PxPyPzE) map[string]float64 {
+// Print and store the results of the event loop: histograms
+// plots in <base>_plots and spin coefficients in <base>_spin.csv
+func report(tot *fileResult, cfg config, base string) {
 
-	// Get the proper basis
-	k, r, n := getSpinBasis(tplus, tminus)
-	
-	// 1. Get ttbar boost
-	ttbar        := fmom.Add(&tplus, &tminus)
-	boost_ttbar  := fmom.BoostOf(ttbar).Scale(-1)
-	
-	// 2. Move tops and leptons in ttbar rest-frame
-	tplus_ttbar  := fmom.Boost(&tplus , boost_ttbar) 
-	tminus_ttbar := fmom.Boost(&tminus, boost_ttbar) 
-	lplus_ttbar  := fmom.Boost(&lplus , boost_ttbar) 	
-	lminus_ttbar := fmom.Boost(&lminus, boost_ttbar) 
-
-	// 3. Move leptons in their top parent rest-frame, from the ttbar rest-frame
-	lplusRF4m  := fmom.Boost(lplus_ttbar , fmom.BoostOf(tplus_ttbar).Scale(-1))
-	lminusRF4m := fmom.Boost(lminus_ttbar, fmom.BoostOf(tminus_ttbar).Scale(-1))
-	lplusRF    := fmom.VecOf(lplusRF4m)
-	lminusRF   := fmom.VecOf(lminusRF4m)
-	
-	// Fill the six cosines
-	getCos := func(a, b r3.Vec, m float64) float64 {
-		return a.Dot(b.Scale(m)) / (r3.Norm(a) * r3.Norm(b.Scale(m)))
+	dirPlots := base + "_plots"
+	err := tot.hs.plot(dirPlots)
+	if err != nil {
+		log.Fatalf("could not plot histograms: %+v", err)
 	}
-	cosTheta := map[string]float64{
-		"k+"  : getCos(lplusRF, k, 1),
-		"r+"  : getCos(lplusRF, r, 1),
-		"n+"  : getCos(lplusRF, n, 1),
-		"k-"  : getCos(lminusRF, k, -1),
-		"r-"  : getCos(lminusRF, r, -1),
-		"n-"  : getCos(lminusRF, n, -1),
-		"dphi": getCos(lplusRF, lminusRF, 1),
+	fmt.Println(" --> Histograms plotted in", dirPlots)
+	fmt.Printf(" --> Spin basis: %v (%d events with the top along the beam, using the fallback r/n axes)\n", cfg.frame, tot.nFallback)
+	if tot.hs.reco {
+		fmt.Printf(" --> Top reconstruction: %d/%d events with missing particles, %d without solution\n",
+			tot.nRecoMiss, tot.nEvt, tot.nRecoFail)
+	}
+	if tot.unfolded != nil {
+		fnameUnf := base + "_unfolding.csv"
+		err = reportUnfolding(tot.unfolded, fnameUnf)
+		if err != nil {
+			log.Fatalf("could not write unfolding table %q: %+v", fnameUnf, err)
+		}
+		err = plotClosure(tot.unfolded, dirPlots)
+		if err != nil {
+			log.Fatalf("could not plot unfolding closure: %+v", err)
+		}
+		fmt.Println(" --> Unfolded distributions stored in", fnameUnf)
+	}
+	if cfg.rw != nil {
+		fnameRw := base + "_reweight.csv"
+		err = reportReweighting(cfg.rw, tot, fnameRw)
+		if err != nil {
+			log.Fatalf("could not write reweighting table %q: %+v", fnameRw, err)
+		}
+		fmt.Println(" --> Reweighting validation stored in", fnameRw)
+	}
+	if tot.ent != nil {
+		fnameEnt := base + "_entanglement.csv"
+		err = reportEntanglement(tot.ent, fnameEnt, dirPlots)
+		if err != nil {
+			log.Fatalf("could not write entanglement table %q: %+v", fnameEnt, err)
+		}
+		fmt.Println(" --> Entanglement observables stored in", fnameEnt)
+	}
+	if tot.diff != nil {
+		fnameDiff := base + "_differential.csv"
+		err = reportDifferential(tot.diff, fnameDiff)
+		if err != nil {
+			log.Fatalf("could not write differential table %q: %+v", fnameDiff, err)
+		}
+		fmt.Println(" --> Differential spin coefficients stored in", fnameDiff)
 	}
 
-	return cosTheta
+	// Results table
+	fnameRes := base + "_spin.csv"
+	res := tot.coeffs.Results()
+	if tot.whel != nil {
+		fit, err := reportWHelicity(tot.whel, dirPlots)
+		if err != nil {
+			log.Fatalf("could not measure the W-helicity fractions: %+v", err)
+		}
+		res = append(res, fit.F0, fit.FL, fit.FR)
+	}
+	printResults(res, tot.coeffs.Skipped)
+	err = writeResults(fnameRes, res)
+	if err != nil {
+		log.Fatalf("could not write results table %q: %+v", fnameRes, err)
+	}
+	fmt.Println(" --> Spin coefficients stored in", fnameRes)
 }
 
-// Get spin basis
-func getSpinBasis(t, tbar fmom.PxPyPzE) (k, r, n r3.Vec) {
-
-	// Get top direction in ttbar rest frame
-	ttbar := fmom.Add(&t, &tbar)
-	top_rest := fmom.Boost(&t, fmom.BoostOf(ttbar).Scale(-1))
-	top_rest_pvec := fmom.VecOf(top_rest)
-	k = top_rest_pvec.Scale(1 / r3.Norm(top_rest_pvec))
-
-	// Get the beam axis (Oz) and coeff to build ortho-normal basis
-	beam_axis := r3.Vec{0, 0, 1}
-	yval := k.Dot(beam_axis)
-	ysign := yval / math.Abs(yval)
-	rval := math.Sqrt(1 - yval*yval)
-
-	// Get r axis: r = sign(y)/r * (beam -y*k)
-	r = beam_axis.Add(k.Scale(-yval))
-	r = r.Scale(1. / rval * ysign)
-
-	// Get n axis: n = sign(y)/r * (beam cross k)
-	n = beam_axis.Cross(k)
-	n = n.Scale(1. / rval * ysign)
-
-	return k, r, n
+// Print the spin coefficients
+func printResults(res []spin.Result, skipped int) {
+	fmt.Printf(" --> Spin coefficients (%d events with undefined cosines skipped)\n", skipped)
+	for _, r := range res {
+		fmt.Printf("     %-5s = %+.4f +/- %.4f\n", r.Name, r.Value, r.Error)
+	}
 }
 
+// Write the spin coefficients into a CSV file
+func writeResults(fname string, res []spin.Result) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"name", "value", "error"})
+	for _, r := range res {
+		w.Write([]string{
+			r.Name,
+			strconv.FormatFloat(r.Value, 'g', -1, 64),
+			strconv.FormatFloat(r.Error, 'g', -1, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
 
 // Helper to define the event variables to load
 func getReadVariables(e *Event) []rtree.ReadVar {
@@ -311,50 +775,39 @@ func getReadVariables(e *Event) []rtree.ReadVar {
 	}
 }
 
-// Event printing
-func printEvent(e Event) {
-	fmt.Println(" * Top quark")
-	fmt.Println("   - pT : ", e.t.pt)
-	fmt.Println("   - Eta: ", e.t.eta)
-	fmt.Println("   - Phi: ", e.t.phi)
-	fmt.Println("   - pid: ", e.t.pid)
-	fmt.Println(" * Bottom quark")
-	fmt.Println("   - pT : ", e.b.pt)
-	fmt.Println("   - Eta: ", e.b.eta)
-	fmt.Println("   - Phi: ", e.b.phi)
-	fmt.Println("   - pid: ", e.b.pid)
-	fmt.Println(" * W-boson")
-	fmt.Println("   - pT : ", e.W.pt)
-	fmt.Println("   - Eta: ", e.W.eta)
-	fmt.Println("   - Phi: ", e.W.phi)
-	fmt.Println("   - pid: ", e.W.pid)
-	fmt.Println(" * Lepton")
-	fmt.Println("   - pT : ", e.l.pt)
-	fmt.Println("   - Eta: ", e.l.eta)
-	fmt.Println("   - Phi: ", e.l.phi)
-	fmt.Println("   - pid: ", e.l.pid)
-	fmt.Println(" * Neutrino")
-	fmt.Println("   - pT : ", e.v.pt)
-	fmt.Println("   - Eta: ", e.v.eta)
-	fmt.Println("   - Phi: ", e.v.phi)
-	fmt.Println("   - pid: ", e.v.pid)
-
-}
-
 // Helper to open a ROOT file
-func openRootFile(fname string) *groot.File {
+func openRootFile(fname string) (*groot.File, error) {
 	f, err := groot.Open(fname)
 	if err != nil {
-		log.Fatalf("could not open ROOT file: %+v", err)
+		return nil, fmt.Errorf("could not open ROOT file %q: %w", fname, err)
 	}
-	return f
+	return f, nil
 }
 
-// Helper to get a TTree
-func getTtree(f *groot.File, tname string) rtree.Tree {
+// Helper to get a TTree, checking the type of the object
+func getTtree(f *groot.File, tname string) (rtree.Tree, error) {
 	obj, err := f.Get(tname)
 	if err != nil {
-		log.Fatalf("could not retrieve tree %q: %+v", tname, err)
+		return nil, fmt.Errorf("could not retrieve tree %q: %w", tname, err)
+	}
+	tree, ok := obj.(rtree.Tree)
+	if !ok {
+		return nil, fmt.Errorf("object %q is a %s, not a TTree", tname, obj.Class())
+	}
+	return tree, nil
+}
+
+// Helper to open a ROOT file and get a TTree. The file is to be
+// closed by the caller if there is no error.
+func openTree(fname, tname string) (*groot.File, rtree.Tree, error) {
+	f, err := openRootFile(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := getTtree(f, tname)
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%q: %w", fname, err)
 	}
-	return obj.(rtree.Tree)
+	return f, tree, nil
 }
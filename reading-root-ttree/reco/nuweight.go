@@ -0,0 +1,135 @@
+// Package reco reconstructs the top quarks of dilepton ttbar events
+// from the charged leptons, the b-jets and the missing transverse
+// momentum, using the neutrino weighting method [e.g. arXiv:1205.3130].
+package reco
+
+import (
+	"math"
+
+	"go-hep.org/x/hep/fmom"
+)
+
+// Options of the reconstruction
+type Options struct {
+	MTop     float64 // top quark mass constraint (GeV)
+	MW       float64 // W boson mass constraint (GeV)
+	SigmaMET float64 // resolution of the missing transverse momentum components (GeV)
+	EtaMax   float64 // the neutrino pseudo-rapidities are scanned in [-EtaMax, EtaMax]
+	EtaStep  float64 // step of the pseudo-rapidity scan
+}
+
+// DefaultOptions are the options used by default
+var DefaultOptions = Options{MTop: 172.5, MW: 80.4, SigmaMET: 10, EtaMax: 4, EtaStep: 0.1}
+
+// Solution is the reconstructed ttbar system
+type Solution struct {
+	Top, AntiTop fmom.PxPyPzE
+	Nu, AntiNu   fmom.PxPyPzE
+
+	// Weight = exp(-dx^2/2s^2 - dy^2/2s^2), comparing the neutrinos
+	// transverse momentum to the measured missing momentum
+	Weight float64
+
+	// The b-jets were swapped with respect to the given assignment
+	Swapped bool
+}
+
+// NeutrinoWeighting reconstructs the two neutrinos of the decays
+// t -> b l+ nu and tbar -> bbar l- nubar, given the missing transverse
+// momentum (metX, metY). Both assignments of the b-jets to the leptons
+// are tried, and the solution with the highest weight is returned.
+// ok is false when no solution satisfies the mass constraints.
+func NeutrinoWeighting(lplus, lminus, b, bbar fmom.PxPyPzE, metX, metY float64, opts Options) (best Solution, ok bool) {
+
+	for _, swap := range []bool{false, true} {
+		bt, btbar := b, bbar
+		if swap {
+			bt, btbar = bbar, b
+		}
+
+		var (
+			nus    = solutions(lplus, bt, opts)
+			nubars = solutions(lminus, btbar, opts)
+		)
+		for _, nu := range nus {
+			for _, nubar := range nubars {
+				dx := nu.Px() + nubar.Px() - metX
+				dy := nu.Py() + nubar.Py() - metY
+				w := math.Exp(-(dx*dx + dy*dy) / (2 * opts.SigmaMET * opts.SigmaMET))
+				if ok && w <= best.Weight {
+					continue
+				}
+				ok = true
+				best = Solution{
+					Top:     sum(lplus, bt, nu),
+					AntiTop: sum(lminus, btbar, nubar),
+					Nu:      nu,
+					AntiNu:  nubar,
+					Weight:  w,
+					Swapped: swap,
+				}
+			}
+		}
+	}
+
+	return best, ok
+}
+
+// Neutrino solutions of the decay t -> b l nu, for all the scanned
+// pseudo-rapidities. For a given eta, the W and top mass constraints
+//
+//	2 pT (El ch - plz sh - plx c - ply s) = mW^2 - ml^2
+//	2 pT (Ebl ch - pblz sh - pblx c - pbly s) = mt^2 - mbl^2
+//
+// with ch = cosh(eta), sh = sinh(eta), c = cos(phi) and s = sin(phi),
+// give a c + b s = g for the neutrino azimuth, then its pT.
+func solutions(l, b fmom.PxPyPzE, opts Options) []fmom.PxPyPzE {
+	var (
+		bl  = fmom.Add(&b, &l)
+		dW  = opts.MW*opts.MW - l.M2()
+		dt  = opts.MTop*opts.MTop - bl.M2()
+		a   = dt*l.Px() - dW*bl.Px()
+		bb  = dt*l.Py() - dW*bl.Py()
+		r   = math.Hypot(a, bb)
+		phi = math.Atan2(bb, a)
+		nus []fmom.PxPyPzE
+	)
+	if r == 0 {
+		return nil
+	}
+
+	n := int(math.Round(2*opts.EtaMax/opts.EtaStep)) + 1
+	for i := 0; i < n; i++ {
+		eta := -opts.EtaMax + float64(i)*opts.EtaStep
+		ch, sh := math.Cosh(eta), math.Sinh(eta)
+		g := dt*(l.E()*ch-l.Pz()*sh) - dW*(bl.E()*ch-bl.Pz()*sh)
+		if math.Abs(g) > r {
+			continue
+		}
+		dphi := math.Acos(g / r)
+		for _, p := range []float64{phi + dphi, phi - dphi} {
+			c, s := math.Cos(p), math.Sin(p)
+			den := 2 * (l.E()*ch - l.Pz()*sh - l.Px()*c - l.Py()*s)
+			if den <= 0 {
+				continue
+			}
+			pt := dW / den
+			if pt <= 0 {
+				continue
+			}
+			nus = append(nus, fmom.NewPxPyPzE(pt*c, pt*s, pt*sh, pt*ch))
+			if dphi == 0 {
+				break
+			}
+		}
+	}
+	return nus
+}
+
+func sum(ps ...fmom.PxPyPzE) fmom.PxPyPzE {
+	var tot fmom.PxPyPzE
+	for i := range ps {
+		tot = *fmom.Add(&tot, &ps[i]).(*fmom.PxPyPzE)
+	}
+	return tot
+}
@@ -0,0 +1,113 @@
+package reco
+
+import (
+	"math"
+	"testing"
+
+	"go-hep.org/x/hep/fmom"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// Massless particle of energy e along dir
+func massless(dir r3.Vec, e float64) fmom.PxPyPzE {
+	d := dir.Scale(e / r3.Norm(dir))
+	return fmom.NewPxPyPzE(d.X, d.Y, d.Z, e)
+}
+
+// Decay t -> b l nu with the masses of the options, for a neutrino of
+// transverse momentum pt, pseudo-rapidity eta and azimuth phi, and a
+// lepton and a b-quark (massless) along dirL and dirB
+func topDecay(pt, eta, phi float64, dirL, dirB r3.Vec, opts Options) (l, b, nu fmom.PxPyPzE) {
+	nu = fmom.NewPxPyPzE(pt*math.Cos(phi), pt*math.Sin(phi), pt*math.Sinh(eta), pt*math.Cosh(eta))
+	var (
+		nl   = dirL.Scale(1 / r3.Norm(dirL))
+		cosT = (nu.Px()*nl.X + nu.Py()*nl.Y + nu.Pz()*nl.Z) / nu.P()
+	)
+	l = massless(nl, opts.MW*opts.MW/(2*nu.E()*(1-cosT)))
+	var (
+		w  = sum(l, nu)
+		nb = dirB.Scale(1 / r3.Norm(dirB))
+		pw = w.Px()*nb.X + w.Py()*nb.Y + w.Pz()*nb.Z
+	)
+	b = massless(nb, (opts.MTop*opts.MTop-opts.MW*opts.MW)/(2*(w.E()-pw)))
+	return l, b, nu
+}
+
+func TestNeutrinoWeighting(t *testing.T) {
+	opts := DefaultOptions
+
+	// Neutrinos at pseudo-rapidities of the scan, so that the true
+	// solution is among the scanned ones
+	var (
+		lplus, b, nu = topDecay(60, 0.5, 0.3,
+			r3.Vec{X: 1, Y: 0.2, Z: 0.4}, r3.Vec{X: 0.3, Y: -1, Z: 0.8}, opts)
+		lminus, bbar, nubar = topDecay(45, -1.2, 2.5,
+			r3.Vec{X: -1, Y: 0.5, Z: -0.3}, r3.Vec{X: -0.2, Y: 1, Z: -1.5}, opts)
+		top     = sum(lplus, b, nu)
+		antitop = sum(lminus, bbar, nubar)
+		metX    = nu.Px() + nubar.Px()
+		metY    = nu.Py() + nubar.Py()
+		wplus   = sum(lplus, nu)
+		wminus  = sum(lminus, nubar)
+	)
+	for _, p := range []struct {
+		name string
+		m, w float64
+	}{
+		{"top", top.M(), opts.MTop},
+		{"anti-top", antitop.M(), opts.MTop},
+		{"W+", wplus.M(), opts.MW},
+		{"W-", wminus.M(), opts.MW},
+	} {
+		if math.Abs(p.m-p.w) > 1e-9 {
+			t.Fatalf("invalid %s mass of the test event: got %g, want %g", p.name, p.m, p.w)
+		}
+	}
+
+	near := func(p, q fmom.PxPyPzE) bool {
+		const tol = 1e-6
+		return math.Abs(p.Px()-q.Px()) < tol && math.Abs(p.Py()-q.Py()) < tol &&
+			math.Abs(p.Pz()-q.Pz()) < tol && math.Abs(p.E()-q.E()) < tol
+	}
+	for _, tc := range []struct {
+		name    string
+		b, bbar fmom.PxPyPzE
+		swapped bool
+	}{
+		{"true assignment", b, bbar, false},
+		{"swapped b-jets", bbar, b, true},
+	} {
+		sol, ok := NeutrinoWeighting(lplus, lminus, tc.b, tc.bbar, metX, metY, opts)
+		if !ok {
+			t.Fatalf("%s: no solution", tc.name)
+		}
+		if sol.Weight < 1-1e-9 || sol.Swapped != tc.swapped {
+			t.Errorf("%s: invalid solution: weight=%g swapped=%v, want 1 %v", tc.name, sol.Weight, sol.Swapped, tc.swapped)
+		}
+		for _, p := range []struct {
+			name      string
+			got, want fmom.PxPyPzE
+		}{
+			{"top", sol.Top, top},
+			{"anti-top", sol.AntiTop, antitop},
+			{"neutrino", sol.Nu, nu},
+			{"anti-neutrino", sol.AntiNu, nubar},
+		} {
+			if !near(p.got, p.want) {
+				t.Errorf("%s: invalid %s: got %v, want %v", tc.name, p.name, p.got, p.want)
+			}
+		}
+	}
+
+	// Lepton and b-jet pairs heavier than the top quark, in both
+	// assignments
+	var (
+		l1 = massless(r3.Vec{Z: 1}, 200)
+		l2 = massless(r3.Vec{X: 0.1, Z: 1}, 150)
+		b1 = massless(r3.Vec{Z: -1}, 200)
+		b2 = massless(r3.Vec{X: 0.1, Z: -1}, 180)
+	)
+	if sol, ok := NeutrinoWeighting(l1, l2, b1, b2, 0, 0, opts); ok {
+		t.Errorf("unexpected solution for m(bl) > mt: %+v", sol)
+	}
+}
@@ -0,0 +1,182 @@
+// Event-by-event reweighting to alternative spin hypotheses
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"go-hep.org/x/hep/groot/rtree"
+
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/spin"
+)
+
+// Reweighting of the events from the nominal spin state to hypotheses
+type reweighting struct {
+	nominal spin.Hypothesis
+	hyps    []spin.Hypothesis
+
+	// Nominal measured on the even entries of the chain, the refit of
+	// the hypotheses being done on the odd ones: refitting the events
+	// used for the nominal would always give back the targets exactly
+	split bool
+}
+
+// Are the coefficients of the reweighted events refitted with the entry
+// of the chain?
+func (rw *reweighting) refitted(entry int64) bool {
+	return !rw.split || entry%2 == 1
+}
+
+// Parse a comma separated list of built-in hypotheses (nospin) and
+// JSON files of hypotheses
+func parseVariations(spec string) ([]spin.Variation, error) {
+	var vs []spin.Variation
+	for _, item := range strings.Split(spec, ",") {
+		switch item = strings.TrimSpace(item); item {
+		case "":
+		case spin.NoSpinCorrelation.Name:
+			vs = append(vs, spin.NoSpinCorrelation)
+		default:
+			fvs, err := spin.ReadVariations(item)
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, fvs...)
+		}
+	}
+	return vs, nil
+}
+
+// Read the nominal spin state from a JSON file holding one hypothesis
+func readNominal(fname string) (spin.Hypothesis, error) {
+	vs, err := spin.ReadVariations(fname)
+	if err != nil {
+		return spin.Hypothesis{}, err
+	}
+	if len(vs) != 1 {
+		return spin.Hypothesis{}, fmt.Errorf("%q: expected 1 nominal hypothesis, got %d", fname, len(vs))
+	}
+	return vs[0].Apply(spin.Hypothesis{}), nil
+}
+
+// Measure the nominal spin state of the processed even entries of the
+// files, in a first pass over the chain
+func measureNominal(fnames []string, cfg config) (spin.Hypothesis, error) {
+	var est spin.Estimator
+	offsets, err := chainOffsets(fnames, cfg.tname)
+	if err != nil {
+		return spin.Hypothesis{}, err
+	}
+	for i, fname := range fnames {
+		err := measureFile(&est, fname, offsets[i], cfg)
+		if err != nil {
+			return spin.Hypothesis{}, err
+		}
+	}
+	return est.Hypothesis("nominal"), nil
+}
+
+// Fill the estimator with the processed even entries of a file, whose
+// first entry is the offset-th of the chain
+func measureFile(est *spin.Estimator, fname string, offset int64, cfg config) error {
+	f, tree, err := openTree(fname, cfg.tname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		e     Event
+		rvars = getReadVariables(&e)
+	)
+	e.w = 1
+	if tree.Branch("w_xec") != nil {
+		rvars = append(rvars, rtree.ReadVar{Name: "w_xec", Value: &e.w})
+	}
+	beg, end := cfg.sample.fileRange(offset, tree.Entries())
+	r, err := rtree.NewReader(tree, rvars, rtree.WithRange(beg, end))
+	if err != nil {
+		return fmt.Errorf("could not create tree reader of %q: %w", fname, err)
+	}
+	defer r.Close()
+	err = r.Read(func(ctx rtree.RCtx) error {
+		entry := offset + ctx.Entry
+		if !cfg.sample.keep(entry) || entry%2 == 1 {
+			return nil
+		}
+		c := spin.ComputeCosines(cfg.frame, get4Vec(e.t), get4Vec(e.tbar), get4Vec(e.lbar), get4Vec(e.l))
+		est.Fill(c, e.w)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not read tree of %q: %w", fname, err)
+	}
+	return nil
+}
+
+// Print the comparison of the target and refitted coefficients of each
+// hypothesis and store it in a CSV file
+func reportReweighting(rw *reweighting, tot *fileResult, fname string) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"hypothesis", "name", "target", "value", "error", "pull"})
+	fmt.Printf(" --> Spin reweighting (%d events with non positive weights)\n", tot.nRwBad)
+	if rw.split {
+		fmt.Println("     refit on the odd entries, the nominal being measured on the even ones")
+	}
+	for k, h := range rw.hyps {
+		var (
+			res  = tot.rwCoeffs[k].Results()
+			tgt  = targets(h)
+			chi2 float64
+			ndf  int
+			diag []string
+		)
+		for _, r := range res {
+			pull := (r.Value - tgt[r.Name]) / r.Error
+			if !math.IsNaN(pull) {
+				chi2 += pull * pull
+				ndf++
+			}
+			if r.Name == "C_kk" || r.Name == "C_rr" || r.Name == "C_nn" {
+				diag = append(diag, fmt.Sprintf("%s = %+.3f (target %+.3f)", r.Name, r.Value, tgt[r.Name]))
+			}
+			w.Write([]string{
+				h.Name,
+				r.Name,
+				strconv.FormatFloat(tgt[r.Name], 'g', -1, 64),
+				strconv.FormatFloat(r.Value, 'g', -1, 64),
+				strconv.FormatFloat(r.Error, 'g', -1, 64),
+				strconv.FormatFloat(pull, 'g', -1, 64),
+			})
+		}
+		fmt.Printf("     %-10s: refit chi2/ndf = %.1f/%d, %s\n", h.Name, chi2, ndf, strings.Join(diag, ", "))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Coefficients expected for a hypothesis, with D = -tr(C)/3
+func targets(h spin.Hypothesis) map[string]float64 {
+	t := map[string]float64{"D": -(h.C[0][0] + h.C[1][1] + h.C[2][2]) / 3}
+	for i, a := range spin.Axes {
+		t["B_"+a+"+"] = h.BP[i]
+		t["B_"+a+"-"] = h.BM[i]
+		for j, b := range spin.Axes {
+			t["C_"+a+b] = h.C[i][j]
+		}
+	}
+	return t
+}
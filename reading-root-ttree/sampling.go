@@ -0,0 +1,96 @@
+// Selection of the processed entries: range, prescale and random sampling
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Entries of the chain of input files to process: the range
+// [first, first+n) of the chain entries, of which one in prescale is
+// kept, then a random fraction frac
+type sampling struct {
+	first    int64
+	n        int64 // -1: up to the end of the chain
+	prescale int64
+	frac     float64
+	seed     int64
+}
+
+// Parse an entry range "beg:end" of the chain (end excluded), where
+// an empty end means up to the end of the chain
+func parseRange(s string) (first, n int64, err error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid entry range %q (expected beg:end)", s)
+	}
+	first, err = strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid entry range %q: %w", s, err)
+	}
+	if s[i+1:] == "" {
+		return first, -1, nil
+	}
+	end, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid entry range %q: %w", s, err)
+	}
+	if end < first {
+		return 0, 0, fmt.Errorf("invalid entry range %q (end < beg)", s)
+	}
+	return first, end - first, nil
+}
+
+// Check the sampling options
+func (s sampling) validate() error {
+	switch {
+	case s.first < 0:
+		return fmt.Errorf("invalid first entry %d", s.first)
+	case s.prescale < 1:
+		return fmt.Errorf("invalid prescale %d (must be >= 1)", s.prescale)
+	case !(s.frac > 0 && s.frac <= 1):
+		return fmt.Errorf("invalid sampled fraction %v (must be in ]0, 1])", s.frac)
+	}
+	return nil
+}
+
+// Range [beg, end) of the entries to read in a file with the given
+// number of entries, whose first entry is the offset-th of the chain
+func (s sampling) fileRange(offset, entries int64) (beg, end int64) {
+	beg = clamp(s.first-offset, 0, entries)
+	end = entries
+	if s.n >= 0 {
+		end = clamp(s.first+s.n-offset, beg, entries)
+	}
+	return beg, end
+}
+
+// Is the entry of the chain kept by the prescale and the random
+// sampling? The decision only depends on the entry and the seed, so
+// it does not depend on the splitting of the chain into files.
+func (s sampling) keep(entry int64) bool {
+	if (entry-s.first)%s.prescale != 0 {
+		return false
+	}
+	return s.frac >= 1 || uniform(s.seed, entry) < s.frac
+}
+
+// Uniform number in [0, 1) from the seed and the entry (splitmix64 hash)
+func uniform(seed, entry int64) float64 {
+	z := uint64(entry)*0x9e3779b97f4a7c15 + uint64(seed)*0xd1b54a32d192ed03
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return float64(z>>11) / (1 << 53)
+}
+
+func clamp(x, min, max int64) int64 {
+	switch {
+	case x < min:
+		return min
+	case x > max:
+		return max
+	}
+	return x
+}
@@ -0,0 +1,181 @@
+// Detector smearing of the partonic events, to emulate reco-level inputs
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+
+	"go-hep.org/x/hep/fmom"
+)
+
+// Resolution describes the response of the detector for a particle
+// type. The relative resolution on the smeared quantity x (E or pT) is
+//
+//	sigma/x = sqrt(S^2/x + N^2/x^2 + C^2 + (L x)^2)
+//
+// and particles outside the acceptance are lost.
+type Resolution struct {
+	Var        string  `json:"var"`        // smeared quantity: e or pt
+	Stochastic float64 `json:"stochastic"` // S (GeV^1/2)
+	Noise      float64 `json:"noise"`      // N (GeV)
+	Constant   float64 `json:"constant"`   // C
+	Linear     float64 `json:"linear"`     // L (1/GeV)
+	Angle      float64 `json:"angle"`      // absolute resolution on eta and phi
+	EtaMax     float64 `json:"eta_max"`    // acceptance in |eta|
+	PtMin      float64 `json:"pt_min"`     // acceptance in pT (GeV)
+}
+
+// Detector holds the resolutions of each particle type, the b-tagging
+// efficiency and the resolution of the missing transverse momentum
+type Detector struct {
+	Electron Resolution `json:"electron"`
+	Muon     Resolution `json:"muon"`
+	Tau      Resolution `json:"tau"`
+	BJet     Resolution `json:"bjet"`
+	BTagEff  float64    `json:"btag_eff"`
+	METNoise float64    `json:"met_noise"` // resolution on each MET component (GeV)
+}
+
+// Default detector, loosely inspired by the ATLAS and CMS performances
+var defaultDetector = Detector{
+	Electron: Resolution{Var: "e", Stochastic: 0.10, Noise: 0.3, Constant: 0.007, Angle: 0.001, EtaMax: 2.47, PtMin: 25},
+	Muon:     Resolution{Var: "pt", Constant: 0.015, Linear: 1e-4, Angle: 0.001, EtaMax: 2.5, PtMin: 25},
+	Tau:      Resolution{Var: "pt", Stochastic: 0.8, Constant: 0.05, Angle: 0.02, EtaMax: 2.5, PtMin: 25},
+	BJet:     Resolution{Var: "e", Stochastic: 0.8, Noise: 3, Constant: 0.05, Angle: 0.02, EtaMax: 2.5, PtMin: 25},
+	BTagEff:  0.77,
+	METNoise: 15,
+}
+
+// Read the detector description, from a JSON file or the default one
+func readDetector(spec string) (*Detector, error) {
+	if spec == "default" {
+		d := defaultDetector
+		return &d, nil
+	}
+
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := defaultDetector
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&d)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode detector %q: %w", spec, err)
+	}
+	for _, r := range []Resolution{d.Electron, d.Muon, d.Tau, d.BJet} {
+		if r.Var != "e" && r.Var != "pt" {
+			return nil, fmt.Errorf("detector %q: invalid smeared quantity %q (expected e or pt)", spec, r.Var)
+		}
+	}
+	return &d, nil
+}
+
+// Resolution of a particle, from its PDG ID
+func (d *Detector) resolution(pid int32) *Resolution {
+	switch abs(pid) {
+	case 11:
+		return &d.Electron
+	case 13:
+		return &d.Muon
+	case 15:
+		return &d.Tau
+	}
+	return &d.BJet
+}
+
+// Smear the visible particles (leptons and b-quarks) of the event and
+// return the smeared event with its missing transverse momentum: the
+// neutrinos pT sum, corrected for the mismeasurement of the visible
+// particles (measured or lost), plus a gaussian noise. Lost or untagged
+// particles have a null pid.
+func (d *Detector) smear(e Event, rng *rand.Rand) (s Event, metX, metY float64) {
+	s = e
+	for _, v := range []Particle{e.v, e.vbar} {
+		p := get4Vec(v)
+		metX += p.Px()
+		metY += p.Py()
+	}
+
+	for _, p := range []*Particle{&s.l, &s.lbar, &s.b, &s.bbar} {
+		if p.pid == 0 {
+			continue
+		}
+		before := get4Vec(*p)
+		d.smearParticle(p, rng)
+
+		// Only the particles lost out of acceptance change the missing
+		// momentum: an untagged b-jet is still measured
+		after := get4Vec(*p)
+		metX -= after.Px() - before.Px()
+		metY -= after.Py() - before.Py()
+		if p.pid != 0 && abs(p.pid) == 5 && rng.Float64() > d.BTagEff {
+			*p = Particle{}
+		}
+	}
+
+	metX += d.METNoise * rng.NormFloat64()
+	metY += d.METNoise * rng.NormFloat64()
+	return s, metX, metY
+}
+
+// Smear a particle, and drop it (null pid) if it is out of acceptance
+func (d *Detector) smearParticle(p *Particle, rng *rand.Rand) {
+	var (
+		r   = d.resolution(p.pid)
+		pt  = float64(p.pt)
+		eta = float64(p.eta) + r.Angle*rng.NormFloat64()
+		phi = float64(p.phi) + r.Angle*rng.NormFloat64()
+		m   = math.Max(float64(p.m), 0)
+	)
+
+	sigma := func(x float64) float64 {
+		return math.Sqrt(r.Stochastic*r.Stochastic/x + r.Noise*r.Noise/(x*x) +
+			r.Constant*r.Constant + r.Linear*r.Linear*x*x)
+	}
+	switch r.Var {
+	case "e":
+		e := math.Sqrt(pt*pt*math.Cosh(eta)*math.Cosh(eta) + m*m)
+		e *= 1 + sigma(e)*rng.NormFloat64()
+		if e <= m {
+			*p = Particle{}
+			return
+		}
+		pt = math.Sqrt(e*e-m*m) / math.Cosh(eta)
+	default:
+		pt *= 1 + sigma(pt)*rng.NormFloat64()
+	}
+
+	if pt < r.PtMin || math.Abs(eta) > r.EtaMax {
+		*p = Particle{}
+		return
+	}
+	p.pt = float32(pt)
+	p.eta = float32(eta)
+	p.phi = float32(math.Remainder(phi, 2*math.Pi))
+}
+
+// Get the four-vector of a particle
+func get4Vec(part Particle) fmom.PxPyPzE {
+	var p fmom.PxPyPzE
+	p.SetPtEtaPhiM(
+		float64(part.pt),
+		float64(part.eta),
+		float64(part.phi),
+		float64(part.m),
+	)
+	return p
+}
+
+func abs(x int32) int32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,131 @@
+package spin
+
+import (
+	"math"
+)
+
+// Axes of the spin basis, in the order used for the coefficients
+var Axes = [3]string{"k", "r", "n"}
+
+// Result is a coefficient measured with its statistical uncertainty
+type Result struct {
+	Name  string
+	Value float64
+	Error float64
+}
+
+// Estimator accumulates the weighted averages of the cosines giving the
+// spin-density matrix coefficients [arXiv:1508.05271]:
+//
+//	B_i± = 3 <cos(theta_i±)>
+//	C_ij = -9 <cos(theta_i+) cos(theta_j-)>
+//	D    = -3 <cos(phi)>
+type Estimator struct {
+	bp, bm [3]mean
+	c      [3][3]mean
+	d      mean
+
+	// Events ignored because of undefined cosines
+	Skipped int
+}
+
+// Fill adds an event with weight w. Events with undefined cosines
+// (e.g. missing particles) are counted in Skipped and ignored.
+func (e *Estimator) Fill(c Cosines, w float64) bool {
+	var (
+		p = [3]float64{c.KP, c.RP, c.NP}
+		m = [3]float64{c.KM, c.RM, c.NM}
+	)
+	for _, x := range append(p[:], append(m[:], c.Dphi)...) {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			e.Skipped++
+			return false
+		}
+	}
+
+	for i := range p {
+		e.bp[i].fill(p[i], w)
+		e.bm[i].fill(m[i], w)
+		for j := range m {
+			e.c[i][j].fill(p[i]*m[j], w)
+		}
+	}
+	e.d.fill(c.Dphi, w)
+	return true
+}
+
+// Merge adds the events accumulated by o
+func (e *Estimator) Merge(o *Estimator) {
+	for i := range e.bp {
+		e.bp[i].add(o.bp[i])
+		e.bm[i].add(o.bm[i])
+		for j := range e.c[i] {
+			e.c[i][j].add(o.c[i][j])
+		}
+	}
+	e.d.add(o.d)
+	e.Skipped += o.Skipped
+}
+
+// Events returns the number of filled events
+func (e *Estimator) Events() int {
+	return e.d.n
+}
+
+// Results returns the polarisations B_i+, B_i-, the correlation
+// matrix C_ij (row by row) and D, with their uncertainties
+func (e *Estimator) Results() []Result {
+	var res []Result
+	add := func(name string, m mean, scale float64) {
+		v, err := m.value()
+		res = append(res, Result{Name: name, Value: scale * v, Error: math.Abs(scale) * err})
+	}
+	for i, a := range Axes {
+		add("B_"+a+"+", e.bp[i], 3)
+	}
+	for i, a := range Axes {
+		add("B_"+a+"-", e.bm[i], 3)
+	}
+	for i, a := range Axes {
+		for j, b := range Axes {
+			add("C_"+a+b, e.c[i][j], -9)
+		}
+	}
+	add("D", e.d, -3)
+	return res
+}
+
+// Weighted average, with the sums needed for its uncertainty
+type mean struct {
+	n                int
+	sw, sw2          float64
+	swx, sw2x, sw2x2 float64
+}
+
+func (m *mean) fill(x, w float64) {
+	m.n++
+	m.sw += w
+	m.sw2 += w * w
+	m.swx += w * x
+	m.sw2x += w * w * x
+	m.sw2x2 += w * w * x * x
+}
+
+func (m *mean) add(o mean) {
+	m.n += o.n
+	m.sw += o.sw
+	m.sw2 += o.sw2
+	m.swx += o.swx
+	m.sw2x += o.sw2x
+	m.sw2x2 += o.sw2x2
+}
+
+// Weighted average and its uncertainty, sqrt(sum w^2 (x-<x>)^2) / sum w
+func (m *mean) value() (float64, float64) {
+	if m.n == 0 || m.sw == 0 {
+		return math.NaN(), math.NaN()
+	}
+	v := m.swx / m.sw
+	v2 := m.sw2x2 - 2*v*m.sw2x + v*v*m.sw2
+	return v, math.Sqrt(math.Max(v2, 0)) / math.Abs(m.sw)
+}
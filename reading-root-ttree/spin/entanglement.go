@@ -0,0 +1,194 @@
+package spin
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Entanglement accumulates the spin correlations of the events to test
+// the entanglement of the ttbar pair and the violation of the CHSH
+// Bell inequality:
+//
+//   - the pair is entangled when D = -3 <cos(phi)> < -1/3 [arXiv:2003.02280]
+//   - the CHSH inequality is violated for some choice of the spin axes
+//     when m12 > 1, m12 being the sum of the two largest eigenvalues
+//     of C^T C, the maximal CHSH value being 2 sqrt(m12) [Horodecki,
+//     Phys. Lett. A200 (1995) 340; arXiv:2102.11883]
+//
+// The uncertainties account for the correlations between the elements
+// of the correlation matrix C, measured on the same events.
+type Entanglement struct {
+	d mean
+	c covMean // cos(theta_i+) cos(theta_j-), i and j in (k, r, n)
+
+	// Events ignored because of undefined cosines
+	Skipped int
+}
+
+// EntanglementResult holds the entanglement and Bell observables
+type EntanglementResult struct {
+	Events int
+	D      Result // entanglement marker
+	M12    Result // sum of the two largest eigenvalues of C^T C
+	CHSH   Result // maximal CHSH value, 2 sqrt(m12)
+}
+
+// Entangled tells whether the entanglement marker is below -1/3 by
+// more than two standard deviations
+func (r EntanglementResult) Entangled() bool {
+	return r.D.Value+2*r.D.Error < -1.0/3
+}
+
+// BellViolation tells whether m12 is above 1 by more than two standard
+// deviations. Being quadratic in C, m12 is biased upwards when C is
+// poorly measured.
+func (r EntanglementResult) BellViolation() bool {
+	return r.M12.Value-2*r.M12.Error > 1
+}
+
+// Fill adds an event with weight w. Events with undefined cosines
+// are counted in Skipped and ignored.
+func (e *Entanglement) Fill(c Cosines, w float64) bool {
+	var (
+		p = [3]float64{c.KP, c.RP, c.NP}
+		m = [3]float64{c.KM, c.RM, c.NM}
+		x [9]float64
+	)
+	for _, v := range append(p[:], append(m[:], c.Dphi)...) {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			e.Skipped++
+			return false
+		}
+	}
+	for i := range p {
+		for j := range m {
+			x[3*i+j] = p[i] * m[j]
+		}
+	}
+	e.c.fill(x, w)
+	e.d.fill(c.Dphi, w)
+	return true
+}
+
+// Merge adds the events accumulated by o
+func (e *Entanglement) Merge(o *Entanglement) {
+	e.d.add(o.d)
+	e.c.add(o.c)
+	e.Skipped += o.Skipped
+}
+
+// Result returns the entanglement and Bell observables, with their
+// uncertainties (linear propagation)
+func (e *Entanglement) Result() EntanglementResult {
+	res := EntanglementResult{Events: e.d.n}
+	d, derr := e.d.value()
+	res.D = Result{Name: "D", Value: -3 * d, Error: 3 * derr}
+
+	mu, cov := e.c.value()
+	if mu == nil {
+		nan := math.NaN()
+		res.M12 = Result{Name: "m12", Value: nan, Error: nan}
+		res.CHSH = Result{Name: "CHSH", Value: nan, Error: nan}
+		return res
+	}
+
+	// C_ij = -9 <cos(theta_i+) cos(theta_j-)>
+	var c [9]float64
+	for k := range c {
+		c[k] = -9 * mu[k]
+	}
+	m12 := func(c [9]float64) float64 {
+		var cm mat.Dense
+		cm.Mul(mat.NewDense(3, 3, c[:]).T(), mat.NewDense(3, 3, c[:]))
+		var eig mat.EigenSym
+		if !eig.Factorize(mat.NewSymDense(3, cm.RawMatrix().Data), false) {
+			return math.NaN()
+		}
+		ev := eig.Values(nil) // ascending order
+		return ev[1] + ev[2]
+	}
+
+	// Gradients of m12 and 2 sqrt(m12) with respect to the C_ij
+	var (
+		v       = m12(c)
+		grad    [9]float64
+		m12Var  float64
+		chshErr float64
+	)
+	for k := range c {
+		h := 1e-6
+		up, down := c, c
+		up[k] += h
+		down[k] -= h
+		grad[k] = (m12(up) - m12(down)) / (2 * h)
+	}
+	for a := range grad {
+		for b := range grad {
+			m12Var += grad[a] * grad[b] * 81 * cov[a][b]
+		}
+	}
+	m12Err := math.Sqrt(math.Max(m12Var, 0))
+	if v > 0 {
+		chshErr = m12Err / math.Sqrt(v)
+	}
+	res.M12 = Result{Name: "m12", Value: v, Error: m12Err}
+	res.CHSH = Result{Name: "CHSH", Value: 2 * math.Sqrt(math.Max(v, 0)), Error: chshErr}
+	return res
+}
+
+// Weighted averages of 9 quantities, with the sums needed for their covariance
+type covMean struct {
+	n     int
+	sw    float64
+	swx   [9]float64
+	sw2   float64
+	sw2x  [9]float64
+	sw2xx [9][9]float64
+}
+
+func (m *covMean) fill(x [9]float64, w float64) {
+	m.n++
+	m.sw += w
+	m.sw2 += w * w
+	for a := range x {
+		m.swx[a] += w * x[a]
+		m.sw2x[a] += w * w * x[a]
+		for b := range x {
+			m.sw2xx[a][b] += w * w * x[a] * x[b]
+		}
+	}
+}
+
+func (m *covMean) add(o covMean) {
+	m.n += o.n
+	m.sw += o.sw
+	m.sw2 += o.sw2
+	for a := range m.swx {
+		m.swx[a] += o.swx[a]
+		m.sw2x[a] += o.sw2x[a]
+		for b := range m.sw2xx[a] {
+			m.sw2xx[a][b] += o.sw2xx[a][b]
+		}
+	}
+}
+
+// Weighted averages and their covariance,
+// sum w^2 (x_a-<x_a>)(x_b-<x_b>) / (sum w)^2
+func (m *covMean) value() ([]float64, [9][9]float64) {
+	var cov [9][9]float64
+	if m.n == 0 || m.sw == 0 {
+		return nil, cov
+	}
+	mu := make([]float64, 9)
+	for a := range mu {
+		mu[a] = m.swx[a] / m.sw
+	}
+	for a := range mu {
+		for b := range mu {
+			v := m.sw2xx[a][b] - mu[a]*m.sw2x[b] - mu[b]*m.sw2x[a] + mu[a]*mu[b]*m.sw2
+			cov[a][b] = v / (m.sw * m.sw)
+		}
+	}
+	return mu, cov
+}
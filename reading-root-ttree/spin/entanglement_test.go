@@ -0,0 +1,177 @@
+package spin
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var (
+	// Diagonal spin correlations, with m12 = 0.5^2 + 0.3^2
+	diagonal = Hypothesis{
+		Name: "diagonal",
+		C:    [3][3]float64{{0.5, 0, 0}, {0, -0.3, 0}, {0, 0, 0.2}},
+	}
+
+	// Maximally entangled spin singlet, C = -1
+	singlet = Hypothesis{
+		Name: "singlet",
+		C:    [3][3]float64{{-1, 0, 0}, {0, -1, 0}, {0, 0, -1}},
+	}
+)
+
+// Entanglement of the events of h, with weights uniform in [0.5, 1.5[,
+// filled in two halves which are then merged
+func fillEntanglement(h Hypothesis, n int, rnd *rand.Rand) *Entanglement {
+	var e, o Entanglement
+	for i, c := range generate(h, n, rnd) {
+		w := 0.5 + rnd.Float64()
+		if i%2 == 0 {
+			e.Fill(c, w)
+			continue
+		}
+		o.Fill(c, w)
+	}
+	e.Merge(&o)
+	return &e
+}
+
+func TestEntanglement(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, tc := range []struct {
+		h         Hypothesis
+		d, m12    float64
+		entangled bool
+		violation bool
+	}{
+		{h: diagonal, d: 0.4 / 3, m12: 0.34},
+		{h: singlet, d: -1, m12: 2, entangled: true, violation: true},
+	} {
+		res := fillEntanglement(tc.h, 100000, rnd).Result()
+		if res.Events != 100000 {
+			t.Errorf("%s: invalid number of events: got %d, want 100000", tc.h.Name, res.Events)
+		}
+		for _, r := range []struct {
+			res  Result
+			want float64
+		}{
+			{res.D, tc.d},
+			{res.M12, tc.m12},
+			{res.CHSH, 2 * math.Sqrt(tc.m12)},
+		} {
+			if math.Abs(r.res.Value-r.want) > 4*r.res.Error {
+				t.Errorf("%s: invalid %s: got %.4f +/- %.4f, want %.4f", tc.h.Name, r.res.Name, r.res.Value, r.res.Error, r.want)
+			}
+		}
+		if math.Abs(res.CHSH.Value-2*math.Sqrt(res.M12.Value)) > tol ||
+			math.Abs(res.CHSH.Error-res.M12.Error/math.Sqrt(res.M12.Value)) > tol {
+			t.Errorf("%s: CHSH %g +/- %g inconsistent with m12 %g +/- %g",
+				tc.h.Name, res.CHSH.Value, res.CHSH.Error, res.M12.Value, res.M12.Error)
+		}
+		if res.Entangled() != tc.entangled || res.BellViolation() != tc.violation {
+			t.Errorf("%s: invalid entanglement and Bell violation: got %v %v, want %v %v",
+				tc.h.Name, res.Entangled(), res.BellViolation(), tc.entangled, tc.violation)
+		}
+	}
+
+	// Undefined cosines and no events
+	var e Entanglement
+	if e.Fill(Cosines{KP: math.NaN()}, 1) || e.Skipped != 1 {
+		t.Errorf("undefined cosines not skipped: %d skipped, want 1", e.Skipped)
+	}
+	if res := e.Result(); !math.IsNaN(res.D.Value) || !math.IsNaN(res.M12.Value) || !math.IsNaN(res.CHSH.Value) {
+		t.Errorf("invalid observables without events: got D=%g m12=%g CHSH=%g, want NaN",
+			res.D.Value, res.M12.Value, res.CHSH.Value)
+	}
+}
+
+func TestEntanglementErrors(t *testing.T) {
+	const (
+		ntoys = 400
+		nevts = 4000
+	)
+	var (
+		rnd  = rand.New(rand.NewSource(1))
+		vals = make(map[string][]float64)
+		errs = make(map[string]float64)
+	)
+	for k := 0; k < ntoys; k++ {
+		res := fillEntanglement(diagonal, nevts, rnd).Result()
+		for _, r := range []Result{res.D, res.M12, res.CHSH} {
+			vals[r.Name] = append(vals[r.Name], r.Value)
+			errs[r.Name] += r.Error / ntoys
+		}
+	}
+
+	// Mean propagated uncertainties against the spread of the toys,
+	// known to 3.5% for 400 toys
+	for name, xs := range vals {
+		var sum, sum2 float64
+		for _, x := range xs {
+			sum += x
+			sum2 += x * x
+		}
+		var (
+			mu  = sum / ntoys
+			rms = math.Sqrt((sum2/ntoys - mu*mu) * ntoys / (ntoys - 1))
+		)
+		if math.Abs(errs[name]-rms) > 0.15*rms {
+			t.Errorf("invalid uncertainty of %s: got %.4f, toys %.4f", name, errs[name], rms)
+		}
+	}
+}
+
+func TestCovMean(t *testing.T) {
+	var (
+		rnd = rand.New(rand.NewSource(1))
+		xs  [][9]float64
+		ws  []float64
+		m   covMean
+	)
+	// Correlated quantities far from zero, with weights of both signs
+	for i := 0; i < 1000; i++ {
+		var (
+			x [9]float64
+			u = rnd.NormFloat64()
+		)
+		for a := range x {
+			x[a] = float64(a) + u + rnd.NormFloat64()
+		}
+		w := 2*rnd.Float64() - 0.5
+		xs, ws = append(xs, x), append(ws, w)
+		m.fill(x, w)
+	}
+
+	var sw float64
+	mu := make([]float64, 9)
+	for k, x := range xs {
+		sw += ws[k]
+		for a := range x {
+			mu[a] += ws[k] * x[a]
+		}
+	}
+	for a := range mu {
+		mu[a] /= sw
+	}
+	gotMu, gotCov := m.value()
+	for a := range mu {
+		if math.Abs(gotMu[a]-mu[a]) > tol {
+			t.Errorf("invalid mean %d: got %g, want %g", a, gotMu[a], mu[a])
+		}
+		for b := range mu {
+			var c float64
+			for k, x := range xs {
+				c += ws[k] * ws[k] * (x[a] - mu[a]) * (x[b] - mu[b])
+			}
+			c /= sw * sw
+			if math.Abs(gotCov[a][b]-c) > tol*math.Abs(c) {
+				t.Errorf("invalid covariance %d %d: got %g, want %g", a, b, gotCov[a][b], c)
+			}
+		}
+	}
+
+	var empty covMean
+	if mu, _ := empty.value(); mu != nil {
+		t.Errorf("invalid means without entries: got %v, want nil", mu)
+	}
+}
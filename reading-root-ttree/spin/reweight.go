@@ -0,0 +1,129 @@
+package spin
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// Hypothesis is a spin state of the ttbar pair, given by the
+// polarisations and the correlation matrix in the (k, r, n) basis.
+// The angular distribution of the leptons directions l+ and l-
+// (normalised to 1 on average) is [arXiv:1508.05271]
+//
+//	1 + B+.l+ + B-.l- - l+.C.l-
+type Hypothesis struct {
+	Name string
+	BP   [3]float64 // B_i+
+	BM   [3]float64 // B_i-
+	C    [3][3]float64
+}
+
+// Density returns the angular distribution of the hypothesis for the
+// cosines of an event
+func (h Hypothesis) Density(c Cosines) float64 {
+	var (
+		p = [3]float64{c.KP, c.RP, c.NP}
+		m = [3]float64{c.KM, c.RM, c.NM}
+		f = 1.0
+	)
+	for i := range p {
+		f += h.BP[i]*p[i] + h.BM[i]*m[i]
+		for j := range m {
+			f -= h.C[i][j] * p[i] * m[j]
+		}
+	}
+	return f
+}
+
+// Weight returns the weight of an event generated with the hypothesis
+// from to follow the hypothesis h. It is 1 when the cosines are
+// undefined, and ok is false in that case or when the weight is not
+// positive (unphysical hypothesis).
+func (h Hypothesis) Weight(from Hypothesis, c Cosines) (w float64, ok bool) {
+	fh, ff := h.Density(c), from.Density(c)
+	if math.IsNaN(fh) || math.IsNaN(ff) || ff <= 0 {
+		return 1, false
+	}
+	w = fh / ff
+	return w, w > 0
+}
+
+// Hypothesis returns the spin state measured by the estimator
+func (e *Estimator) Hypothesis(name string) Hypothesis {
+	h := Hypothesis{Name: name}
+	for i := range h.BP {
+		bp, _ := e.bp[i].value()
+		bm, _ := e.bm[i].value()
+		h.BP[i], h.BM[i] = 3*bp, 3*bm
+		for j := range h.C[i] {
+			c, _ := e.c[i][j].value()
+			h.C[i][j] = -9 * c
+		}
+	}
+	return h
+}
+
+// Variation describes a hypothesis with respect to a nominal one:
+// the given polarisations and correlation matrix replace the nominal
+// ones, then the shifts DBP, DBM and DC are added (e.g. the linear
+// contribution of a new physics coupling).
+type Variation struct {
+	Name string         `json:"name"`
+	BP   *[3]float64    `json:"b_plus"`
+	BM   *[3]float64    `json:"b_minus"`
+	C    *[3][3]float64 `json:"c"`
+	DBP  [3]float64     `json:"db_plus"`
+	DBM  [3]float64     `json:"db_minus"`
+	DC   [3][3]float64  `json:"dc"`
+}
+
+// NoSpinCorrelation keeps the polarisations and removes the spin correlations
+var NoSpinCorrelation = Variation{Name: "nospin", C: &[3][3]float64{}}
+
+// Apply returns the hypothesis obtained by varying nominal
+func (v Variation) Apply(nominal Hypothesis) Hypothesis {
+	h := nominal
+	h.Name = v.Name
+	if v.BP != nil {
+		h.BP = *v.BP
+	}
+	if v.BM != nil {
+		h.BM = *v.BM
+	}
+	if v.C != nil {
+		h.C = *v.C
+	}
+	for i := range h.BP {
+		h.BP[i] += v.DBP[i]
+		h.BM[i] += v.DBM[i]
+		for j := range h.C[i] {
+			h.C[i][j] += v.DC[i][j]
+		}
+	}
+	return h
+}
+
+// ReadVariations reads a JSON list of variations
+func ReadVariations(fname string) ([]Variation, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var vs []Variation
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&vs)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode spin hypotheses %q: %w", fname, err)
+	}
+	for _, v := range vs {
+		if v.Name == "" {
+			return nil, fmt.Errorf("spin hypotheses %q: missing name", fname)
+		}
+	}
+	return vs, nil
+}
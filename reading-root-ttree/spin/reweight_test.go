@@ -0,0 +1,206 @@
+package spin
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Draw n events following the angular distribution of h, by rejection
+// of leptons uniformly distributed on the sphere
+func generate(h Hypothesis, n int, rnd *rand.Rand) []Cosines {
+	var (
+		evts = make([]Cosines, 0, n)
+		fmax = 1.0
+	)
+	for i := range h.BP {
+		fmax += math.Abs(h.BP[i]) + math.Abs(h.BM[i])
+		for j := range h.C[i] {
+			fmax += math.Abs(h.C[i][j])
+		}
+	}
+	sphere := func() [3]float64 {
+		var (
+			z   = 2*rnd.Float64() - 1
+			phi = 2 * math.Pi * rnd.Float64()
+			s   = math.Sqrt(1 - z*z)
+		)
+		return [3]float64{s * math.Cos(phi), s * math.Sin(phi), z}
+	}
+	for len(evts) < n {
+		p, m := sphere(), sphere()
+		c := Cosines{
+			KP: p[0], RP: p[1], NP: p[2],
+			KM: m[0], RM: m[1], NM: m[2],
+			Dphi: p[0]*m[0] + p[1]*m[1] + p[2]*m[2],
+		}
+		if rnd.Float64()*fmax < h.Density(c) {
+			evts = append(evts, c)
+		}
+	}
+	return evts
+}
+
+// Check that the coefficients measured by e are those of h, within
+// nsigma uncertainties
+func checkHypothesis(t *testing.T, e *Estimator, h Hypothesis, nsigma float64) {
+	t.Helper()
+
+	want := make(map[string]float64)
+	for i, a := range Axes {
+		want["B_"+a+"+"] = h.BP[i]
+		want["B_"+a+"-"] = h.BM[i]
+		for j, b := range Axes {
+			want["C_"+a+b] = h.C[i][j]
+		}
+	}
+	for _, r := range e.Results() {
+		v, ok := want[r.Name]
+		if !ok {
+			continue
+		}
+		if math.Abs(r.Value-v) > nsigma*r.Error {
+			t.Errorf("%s: invalid %s: got %.4f +/- %.4f, want %.4f", h.Name, r.Name, r.Value, r.Error, v)
+		}
+	}
+}
+
+func TestReweightNoSpin(t *testing.T) {
+	var (
+		rnd     = rand.New(rand.NewSource(1))
+		nominal = Hypothesis{
+			Name: "nominal",
+			BP:   [3]float64{0.1, 0, 0},
+			BM:   [3]float64{-0.05, 0, 0},
+			C:    [3][3]float64{{0.3, 0.1, 0}, {0.1, 0.2, 0}, {0, 0, 0.25}},
+		}
+		nospin  = NoSpinCorrelation.Apply(nominal)
+		gen, rw Estimator
+	)
+	for _, c := range generate(nominal, 200000, rnd) {
+		gen.Fill(c, 1)
+		w, ok := nospin.Weight(nominal, c)
+		if !ok {
+			t.Fatalf("invalid weight %g for %+v", w, c)
+		}
+		rw.Fill(c, w)
+	}
+	checkHypothesis(t, &gen, nominal, 4)
+	checkHypothesis(t, &rw, nospin, 4)
+	if nospin.C != ([3][3]float64{}) || nospin.BP != nominal.BP || nospin.BM != nominal.BM {
+		t.Errorf("invalid hypothesis without spin correlation: %+v", nospin)
+	}
+}
+
+func TestWeight(t *testing.T) {
+	var (
+		from = Hypothesis{Name: "from"}
+		c    = Cosines{KP: 1, KM: 1}
+		nan  = math.NaN()
+	)
+	for _, tc := range []struct {
+		name string
+		h    Hypothesis
+		from Hypothesis
+		c    Cosines
+		w    float64
+		ok   bool
+	}{
+		{"positive", Hypothesis{C: [3][3]float64{{-0.5}}}, from, c, 1.5, true},
+		{"zero", Hypothesis{C: [3][3]float64{{1}}}, from, c, 0, false},
+		{"negative", Hypothesis{C: [3][3]float64{{2}}}, from, c, -1, false},
+		{"zero origin", from, Hypothesis{C: [3][3]float64{{1}}}, c, 1, false},
+		{"undefined cosines", from, from, Cosines{KP: nan, KM: nan}, 1, false},
+	} {
+		w, ok := tc.h.Weight(tc.from, tc.c)
+		if math.Abs(w-tc.w) > tol || ok != tc.ok {
+			t.Errorf("%s: invalid weight: got (%g, %v), want (%g, %v)", tc.name, w, ok, tc.w, tc.ok)
+		}
+	}
+}
+
+func TestReadVariations(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "variations.json")
+	err := os.WriteFile(fname, []byte(`[
+  {"name": "shift", "dc": [[0.1, 0, 0], [0, 0, 0], [0, 0, -0.2]], "db_plus": [0, 0.05, 0]},
+  {"name": "replace", "c": [[1, 0, 0], [0, 1, 0], [0, 0, 1]], "dc": [[0.1, 0, 0], [0, 0, 0], [0, 0, 0]]}
+]`), 0644)
+	if err != nil {
+		t.Fatalf("could not write variations: %+v", err)
+	}
+	vs, err := ReadVariations(fname)
+	if err != nil {
+		t.Fatalf("could not read variations: %+v", err)
+	}
+	if len(vs) != 2 {
+		t.Fatalf("invalid number of variations: got %d, want 2", len(vs))
+	}
+
+	nominal := Hypothesis{
+		Name: "nominal",
+		BP:   [3]float64{0.1, 0.2, 0.3},
+		BM:   [3]float64{-0.1, -0.2, -0.3},
+		C:    [3][3]float64{{0.3, 0.1, 0}, {0.1, 0.2, 0}, {0, 0, 0.25}},
+	}
+	for _, tc := range []struct {
+		v    Variation
+		want Hypothesis
+	}{
+		{
+			v: vs[0],
+			want: Hypothesis{
+				Name: "shift",
+				BP:   [3]float64{0.1, 0.25, 0.3},
+				BM:   nominal.BM,
+				C:    [3][3]float64{{0.4, 0.1, 0}, {0.1, 0.2, 0}, {0, 0, 0.05}},
+			},
+		},
+		{
+			v: vs[1],
+			want: Hypothesis{
+				Name: "replace",
+				BP:   nominal.BP,
+				BM:   nominal.BM,
+				C:    [3][3]float64{{1.1, 0, 0}, {0, 1, 0}, {0, 0, 1}},
+			},
+		},
+	} {
+		got := tc.v.Apply(nominal)
+		if got.Name != tc.want.Name || got.BP != tc.want.BP || got.BM != tc.want.BM {
+			t.Errorf("%s: invalid polarisations: got %+v, want %+v", tc.v.Name, got, tc.want)
+		}
+		for i := range got.C {
+			for j := range got.C[i] {
+				if math.Abs(got.C[i][j]-tc.want.C[i][j]) > tol {
+					t.Errorf("%s: invalid C_%s%s: got %g, want %g", tc.v.Name, Axes[i], Axes[j], got.C[i][j], tc.want.C[i][j])
+				}
+			}
+		}
+	}
+	if nominal.C[0][0] != 0.3 {
+		t.Errorf("the nominal hypothesis was modified")
+	}
+
+	// Shipped variations
+	_, err = ReadVariations(filepath.Join("..", "hypotheses", "variations.json"))
+	if err != nil {
+		t.Errorf("could not read the shipped variations: %+v", err)
+	}
+
+	// Invalid variations
+	for _, content := range []string{
+		`[{"dc": [[0.1, 0, 0], [0, 0, 0], [0, 0, 0]]}]`,
+		`[{"name": "typo", "d_c": [[0.1, 0, 0], [0, 0, 0], [0, 0, 0]]}]`,
+	} {
+		err = os.WriteFile(fname, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("could not write variations: %+v", err)
+		}
+		_, err = ReadVariations(fname)
+		if err == nil {
+			t.Errorf("no error for the variations %s", content)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package spin computes the spin-correlation observables of top-antitop
+// quark pairs decaying into leptons [e.g. arXiv:1612.07004].
+package spin
+
+import (
+	"fmt"
+	"math"
+
+	"go-hep.org/x/hep/fmom"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// Cosines holds the angles of the charged leptons in their parent top
+// rest frame, with respect to the (k, r, n) spin basis axes
+type Cosines struct {
+	KP, RP, NP float64 // positive lepton, with respect to k, r and n
+	KM, RM, NM float64 // negative lepton, with respect to -k, -r and -n
+
+	// Cosine of the angle between the two leptons,
+	// each one being taken in its parent top rest frame
+	Dphi float64
+
+	// The r and n axes were undefined and given by the fallback basis
+	Fallback bool
+}
+
+// Frame is the choice of spin basis
+type Frame int
+
+const (
+	// Helicity basis used by ATLAS and CMS: k is the top direction in
+	// the ttbar rest frame, r is in the plane of k and of the proton
+	// direction (+z) and n is orthogonal to both
+	Helicity Frame = iota
+
+	// Beam basis: k is the beam axis, r and n the x and y axes
+	Beam
+)
+
+// Below this value of |k x beam|, the top is considered along the beam
+// and the helicity basis r and n axes are built from the x axis instead
+const collinearTol = 1e-9
+
+// ParseFrame returns the spin basis from its name, helicity or beam
+func ParseFrame(name string) (Frame, error) {
+	switch name {
+	case "helicity":
+		return Helicity, nil
+	case "beam":
+		return Beam, nil
+	}
+	return 0, fmt.Errorf("unknown spin basis %q (expected helicity or beam)", name)
+}
+
+func (f Frame) String() string {
+	if f == Beam {
+		return "beam"
+	}
+	return "helicity"
+}
+
+// ComputeCosines computes the spin-related cosines from the top,
+// anti-top and the leptons they decay into, in the given basis
+func ComputeCosines(frame Frame, tplus, tminus, lplus, lminus fmom.PxPyPzE) Cosines {
+
+	// Get the proper basis
+	k, r, n, fallback := Basis(frame, tplus, tminus)
+
+	// 1. Get ttbar boost
+	ttbar := fmom.Add(&tplus, &tminus)
+	boost_ttbar := fmom.BoostOf(ttbar).Scale(-1)
+
+	// 2. Move tops and leptons in ttbar rest-frame
+	tplus_ttbar := fmom.Boost(&tplus, boost_ttbar)
+	tminus_ttbar := fmom.Boost(&tminus, boost_ttbar)
+	lplus_ttbar := fmom.Boost(&lplus, boost_ttbar)
+	lminus_ttbar := fmom.Boost(&lminus, boost_ttbar)
+
+	// 3. Move leptons in their top parent rest-frame, from the ttbar rest-frame
+	lplusRF4m := fmom.Boost(lplus_ttbar, fmom.BoostOf(tplus_ttbar).Scale(-1))
+	lminusRF4m := fmom.Boost(lminus_ttbar, fmom.BoostOf(tminus_ttbar).Scale(-1))
+	lplusRF := fmom.VecOf(lplusRF4m)
+	lminusRF := fmom.VecOf(lminusRF4m)
+
+	// Fill the six cosines
+	getCos := func(a, b r3.Vec, m float64) float64 {
+		return a.Dot(b.Scale(m)) / (r3.Norm(a) * r3.Norm(b.Scale(m)))
+	}
+	return Cosines{
+		KP:       getCos(lplusRF, k, 1),
+		RP:       getCos(lplusRF, r, 1),
+		NP:       getCos(lplusRF, n, 1),
+		KM:       getCos(lminusRF, k, -1),
+		RM:       getCos(lminusRF, r, -1),
+		NM:       getCos(lminusRF, n, -1),
+		Dphi:     getCos(lplusRF, lminusRF, 1),
+		Fallback: fallback,
+	}
+}
+
+// Basis returns the (k, r, n) spin basis. In the helicity basis, r and n
+// are undefined when the top is along the beam: they are then built from
+// the x axis instead of the beam axis, and fallback is true.
+func Basis(frame Frame, t, tbar fmom.PxPyPzE) (k, r, n r3.Vec, fallback bool) {
+
+	if frame == Beam {
+		k = r3.Vec{X: 0, Y: 0, Z: 1}
+		r = r3.Vec{X: 1, Y: 0, Z: 0}
+		n = r3.Vec{X: 0, Y: 1, Z: 0}
+		return k, r, n, false
+	}
+
+	// Get top direction in ttbar rest frame
+	ttbar := fmom.Add(&t, &tbar)
+	top_rest := fmom.Boost(&t, fmom.BoostOf(ttbar).Scale(-1))
+	top_rest_pvec := fmom.VecOf(top_rest)
+	k = top_rest_pvec.Scale(1 / r3.Norm(top_rest_pvec))
+
+	// Get the beam axis (Oz) and coeff to build ortho-normal basis
+	beam_axis := r3.Vec{X: 0, Y: 0, Z: 1}
+	yval := k.Dot(beam_axis)
+	ysign := 1.
+	if yval < 0 {
+		ysign = -1
+	}
+	rval := math.Sqrt(math.Max(1-yval*yval, 0))
+	if rval < collinearTol {
+		x_axis := r3.Vec{X: 1, Y: 0, Z: 0}
+		r = x_axis.Add(k.Scale(-k.Dot(x_axis)))
+		r = r.Scale(1 / r3.Norm(r))
+		n = r.Cross(k)
+		return k, r, n, true
+	}
+
+	// Get r axis: r = sign(y)/r * (beam -y*k)
+	r = beam_axis.Add(k.Scale(-yval))
+	r = r.Scale(1. / rval * ysign)
+
+	// Get n axis: n = sign(y)/r * (beam cross k)
+	n = beam_axis.Cross(k)
+	n = n.Scale(1. / rval * ysign)
+
+	return k, r, n, false
+}
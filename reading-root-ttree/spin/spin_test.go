@@ -0,0 +1,203 @@
+package spin
+
+import (
+	"math"
+	"testing"
+
+	"go-hep.org/x/hep/fmom"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+const (
+	mtop = 173.0
+	tol  = 1e-9
+)
+
+// Back-to-back top and anti-top with momentum p along dir in the ttbar
+// rest frame, the pair being boosted along the beam with velocity beta
+type config struct {
+	dir  r3.Vec
+	p    float64
+	beta float64
+}
+
+func (c config) tops() (t, tbar fmom.PxPyPzE) {
+	var (
+		d = c.dir.Scale(c.p / r3.Norm(c.dir))
+		e = math.Sqrt(c.p*c.p + mtop*mtop)
+	)
+	t = fmom.NewPxPyPzE(d.X, d.Y, d.Z, e)
+	tbar = fmom.NewPxPyPzE(-d.X, -d.Y, -d.Z, e)
+	return c.lab(t), c.lab(tbar)
+}
+
+// Massless lepton emitted along dir in the rest frame of the top, whose
+// momentum top is given in the ttbar rest frame
+func (c config) lepton(top fmom.PxPyPzE, dir r3.Vec) fmom.PxPyPzE {
+	d := dir.Scale(30 / r3.Norm(dir))
+	l := fmom.NewPxPyPzE(d.X, d.Y, d.Z, 30)
+	return c.lab(*fmom.Boost(&l, fmom.BoostOf(&top)).(*fmom.PxPyPzE))
+}
+
+// Boost from the ttbar rest frame to the laboratory
+func (c config) lab(p fmom.PxPyPzE) fmom.PxPyPzE {
+	return *fmom.Boost(&p, r3.Vec{Z: c.beta}).(*fmom.PxPyPzE)
+}
+
+func TestBasis(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		frame    Frame
+		cfg      config
+		k, r, n  r3.Vec
+		fallback bool
+	}{
+		{
+			name:  "helicity, top along x",
+			frame: Helicity,
+			cfg:   config{dir: r3.Vec{X: 1}, p: 100},
+			k:     r3.Vec{X: 1}, r: r3.Vec{Z: 1}, n: r3.Vec{Y: 1},
+		},
+		{
+			name:  "helicity, boosted top in the x-z plane",
+			frame: Helicity,
+			cfg:   config{dir: r3.Vec{X: 1, Z: 1}, p: 250, beta: 0.6},
+			k:     r3.Vec{X: 1 / math.Sqrt2, Z: 1 / math.Sqrt2},
+			r:     r3.Vec{X: -1 / math.Sqrt2, Z: 1 / math.Sqrt2},
+			n:     r3.Vec{Y: 1},
+		},
+		{
+			name:  "helicity, backward top",
+			frame: Helicity,
+			cfg:   config{dir: r3.Vec{Y: 1, Z: -1}, p: 50, beta: -0.3},
+			k:     r3.Vec{Y: 1 / math.Sqrt2, Z: -1 / math.Sqrt2},
+			r:     r3.Vec{Y: -1 / math.Sqrt2, Z: -1 / math.Sqrt2},
+			n:     r3.Vec{X: 1},
+		},
+		{
+			name:     "helicity, top along the beam (fallback)",
+			frame:    Helicity,
+			cfg:      config{dir: r3.Vec{Z: 1}, p: 100, beta: 0.4},
+			k:        r3.Vec{Z: 1},
+			r:        r3.Vec{X: 1},
+			n:        r3.Vec{Y: -1},
+			fallback: true,
+		},
+		{
+			name:  "beam",
+			frame: Beam,
+			cfg:   config{dir: r3.Vec{X: 1, Y: 2, Z: 3}, p: 100, beta: 0.5},
+			k:     r3.Vec{Z: 1}, r: r3.Vec{X: 1}, n: r3.Vec{Y: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			top, tbar := tc.cfg.tops()
+			k, r, n, fallback := Basis(tc.frame, top, tbar)
+			if fallback != tc.fallback {
+				t.Errorf("invalid fallback: got %v, want %v", fallback, tc.fallback)
+			}
+
+			// Orthonormality
+			axes := []r3.Vec{k, r, n}
+			for i := range axes {
+				for j := range axes {
+					want := 0.0
+					if i == j {
+						want = 1
+					}
+					if got := axes[i].Dot(axes[j]); math.Abs(got-want) > tol {
+						t.Errorf("axes %s.%s = %g, want %g", Axes[i], Axes[j], got, want)
+					}
+				}
+			}
+
+			for _, v := range []struct {
+				name      string
+				got, want r3.Vec
+			}{
+				{"k", k, tc.k}, {"r", r, tc.r}, {"n", n, tc.n},
+			} {
+				if r3.Norm(v.got.Sub(v.want)) > tol {
+					t.Errorf("invalid %s axis: got %+v, want %+v", v.name, v.got, v.want)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeCosines(t *testing.T) {
+	var (
+		x = r3.Vec{X: 1}
+		y = r3.Vec{Y: 1}
+		z = r3.Vec{Z: 1}
+	)
+	for _, tc := range []struct {
+		name          string
+		frame         Frame
+		cfg           config
+		lplus, lminus r3.Vec // lepton directions in the top rest frames
+		want          Cosines
+	}{
+		{
+			name:  "helicity, leptons along the tops",
+			frame: Helicity,
+			cfg:   config{dir: x, p: 100},
+			lplus: x, lminus: x.Scale(-1),
+			want: Cosines{KP: 1, KM: 1, Dphi: -1},
+		},
+		{
+			name:  "helicity, leptons along r and n",
+			frame: Helicity,
+			cfg:   config{dir: x, p: 300, beta: 0.7},
+			lplus: z, lminus: y,
+			want: Cosines{RP: 1, NM: -1},
+		},
+		{
+			name:  "helicity, lepton at 60 degrees from k",
+			frame: Helicity,
+			cfg:   config{dir: r3.Vec{Y: math.Sqrt(3) / 2, Z: 0.5}, p: 150, beta: -0.2},
+			lplus: z, lminus: x,
+			want: Cosines{KP: 0.5, RP: math.Sqrt(3) / 2, NM: 1, Dphi: 0},
+		},
+		{
+			name:  "helicity, top along the beam (fallback)",
+			frame: Helicity,
+			cfg:   config{dir: z, p: 100, beta: 0.4},
+			lplus: x, lminus: z,
+			want: Cosines{RP: 1, KM: -1, Fallback: true},
+		},
+		{
+			name:  "beam",
+			frame: Beam,
+			cfg:   config{dir: r3.Vec{X: 1, Y: 1}, p: 200, beta: 0.5},
+			lplus: z, lminus: y.Scale(-1),
+			want: Cosines{KP: 1, NM: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				top, tbar = tc.cfg.tops()
+				rest      = config{dir: tc.cfg.dir, p: tc.cfg.p}
+				t0, tbar0 = rest.tops()
+				lplus     = tc.cfg.lepton(t0, tc.lplus)
+				lminus    = tc.cfg.lepton(tbar0, tc.lminus)
+				got       = ComputeCosines(tc.frame, top, tbar, lplus, lminus)
+			)
+			if got.Fallback != tc.want.Fallback {
+				t.Errorf("invalid fallback: got %v, want %v", got.Fallback, tc.want.Fallback)
+			}
+			for _, c := range []struct {
+				name      string
+				got, want float64
+			}{
+				{"k+", got.KP, tc.want.KP}, {"r+", got.RP, tc.want.RP}, {"n+", got.NP, tc.want.NP},
+				{"k-", got.KM, tc.want.KM}, {"r-", got.RM, tc.want.RM}, {"n-", got.NM, tc.want.NM},
+				{"dphi", got.Dphi, tc.want.Dphi},
+			} {
+				if math.Abs(c.got-c.want) > 1e-6 {
+					t.Errorf("invalid cos(%s): got %g, want %g", c.name, c.got, c.want)
+				}
+			}
+		})
+	}
+}
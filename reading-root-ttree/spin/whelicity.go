@@ -0,0 +1,174 @@
+package spin
+
+import (
+	"fmt"
+	"math"
+
+	"go-hep.org/x/hep/fmom"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+// CosThetaStar returns the cosine of the angle between the charged
+// lepton in the W rest frame and the W direction in the top rest frame
+func CosThetaStar(t, w, l fmom.PxPyPzE) float64 {
+
+	// W and lepton in the top rest frame
+	boostTop := fmom.BoostOf(&t).Scale(-1)
+	wTop := fmom.Boost(&w, boostTop)
+	lTop := fmom.Boost(&l, boostTop)
+
+	// Lepton in the W rest frame, from the top rest frame
+	lW := fmom.VecOf(fmom.Boost(lTop, fmom.BoostOf(wTop).Scale(-1)))
+	wDir := fmom.VecOf(wTop)
+	return lW.Dot(wDir) / (r3.Norm(lW) * r3.Norm(wDir))
+}
+
+// WHelicity accumulates the cos(theta*) distribution of the leptons to
+// measure the W-helicity fractions. For the top (and the anti-top, with
+// the CP conjugated fractions), the distribution is
+//
+//	3/8 (1 - x)^2 F_L + 3/4 (1 - x^2) F_0 + 3/8 (1 + x)^2 F_R
+type WHelicity struct {
+	sumw  []float64 // bins of cos(theta*) in [-1, 1]
+	sumw2 []float64
+
+	// Entries ignored because of an undefined cos(theta*)
+	Skipped int
+}
+
+// NewWHelicity returns an accumulator with bins of cos(theta*)
+func NewWHelicity(bins int) *WHelicity {
+	return &WHelicity{sumw: make([]float64, bins), sumw2: make([]float64, bins)}
+}
+
+// Fill adds a lepton with weight w
+func (h *WHelicity) Fill(cos, w float64) bool {
+	if math.IsNaN(cos) || cos < -1 || cos > 1 {
+		h.Skipped++
+		return false
+	}
+	i := int((cos + 1) / 2 * float64(len(h.sumw)))
+	if i == len(h.sumw) {
+		i--
+	}
+	h.sumw[i] += w
+	h.sumw2[i] += w * w
+	return true
+}
+
+// Merge adds the entries accumulated by o
+func (h *WHelicity) Merge(o *WHelicity) {
+	for i := range h.sumw {
+		h.sumw[i] += o.sumw[i]
+		h.sumw2[i] += o.sumw2[i]
+	}
+	h.Skipped += o.Skipped
+}
+
+// Values returns the weighted counts of the bins and their uncertainties
+func (h *WHelicity) Values() (values, errs []float64) {
+	errs = make([]float64, len(h.sumw2))
+	for i, w2 := range h.sumw2 {
+		errs[i] = math.Sqrt(w2)
+	}
+	return append([]float64(nil), h.sumw...), errs
+}
+
+// WHelicityFit holds the fitted W-helicity fractions
+type WHelicityFit struct {
+	F0, FL, FR Result
+	Norm       float64 // fitted weighted number of leptons
+	Chi2       float64
+	Ndf        int
+}
+
+// Density returns the fitted cos(theta*) distribution, normalised to Norm
+func (f WHelicityFit) Density(x float64) float64 {
+	return f.Norm * (3.0/8*(1-x)*(1-x)*f.FL.Value + 3.0/4*(1-x*x)*f.F0.Value + 3.0/8*(1+x)*(1+x)*f.FR.Value)
+}
+
+// Fit fits the numbers of leptons of each helicity to the binned
+// cos(theta*) distribution (linear chi2 fit of the analytic templates,
+// integrated over the bins), and returns the fractions with their
+// uncertainties, accounting for their correlations
+func (h *WHelicity) Fit() (WHelicityFit, error) {
+	var (
+		nb    = len(h.sumw)
+		width = 2 / float64(nb)
+		rows  []int
+	)
+	for i, w2 := range h.sumw2 {
+		if w2 > 0 {
+			rows = append(rows, i)
+		}
+	}
+	if len(rows) < 3 {
+		return WHelicityFit{}, fmt.Errorf("not enough filled bins to fit the W-helicity fractions (%d)", len(rows))
+	}
+
+	// Templates integrated over the bins (columns: L, 0, R), weighted
+	// by the inverse uncertainties of the bins
+	var (
+		a = mat.NewDense(len(rows), 3, nil)
+		y = mat.NewVecDense(len(rows), nil)
+	)
+	for k, i := range rows {
+		var (
+			lo  = -1 + float64(i)*width
+			hi  = lo + width
+			sig = math.Sqrt(h.sumw2[i])
+		)
+		a.Set(k, 0, (math.Pow(1-lo, 3)-math.Pow(1-hi, 3))/8/sig)
+		a.Set(k, 1, 3.0/4*((hi-hi*hi*hi/3)-(lo-lo*lo*lo/3))/sig)
+		a.Set(k, 2, (math.Pow(1+hi, 3)-math.Pow(1+lo, 3))/8/sig)
+		y.SetVec(k, h.sumw[i]/sig)
+	}
+
+	// Normal equations: n = (A^T A)^-1 A^T y, with covariance (A^T A)^-1
+	var (
+		ata, cov mat.Dense
+		aty, n   mat.VecDense
+		resid    mat.VecDense
+	)
+	ata.Mul(a.T(), a)
+	err := cov.Inverse(&ata)
+	if err != nil {
+		return WHelicityFit{}, fmt.Errorf("could not fit the W-helicity fractions: %w", err)
+	}
+	aty.MulVec(a.T(), y)
+	n.MulVec(&cov, &aty)
+	resid.MulVec(a, &n)
+	resid.SubVec(&resid, y)
+
+	// Fractions F_k = n_k / sum(n), with the Jacobian of the normalisation
+	var (
+		sum = n.AtVec(0) + n.AtVec(1) + n.AtVec(2)
+		jac = mat.NewDense(3, 3, nil)
+		fc  mat.Dense
+	)
+	if sum <= 0 {
+		return WHelicityFit{}, fmt.Errorf("could not fit the W-helicity fractions: non positive normalisation %g", sum)
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			d := 0.0
+			if i == j {
+				d = 1
+			}
+			jac.Set(i, j, (d*sum-n.AtVec(i))/(sum*sum))
+		}
+	}
+	fc.Product(jac, &cov, jac.T())
+	frac := func(name string, i int) Result {
+		return Result{Name: name, Value: n.AtVec(i) / sum, Error: math.Sqrt(math.Max(fc.At(i, i), 0))}
+	}
+	return WHelicityFit{
+		FL:   frac("F_L", 0),
+		F0:   frac("F_0", 1),
+		FR:   frac("F_R", 2),
+		Norm: sum,
+		Chi2: mat.Dot(&resid, &resid),
+		Ndf:  len(rows) - 3,
+	}, nil
+}
@@ -0,0 +1,144 @@
+package spin
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"go-hep.org/x/hep/fmom"
+	"gonum.org/v1/gonum/spatial/r3"
+)
+
+func TestCosThetaStar(t *testing.T) {
+	const (
+		mw = 80.4
+		mb = 4.7
+	)
+	var (
+		top = fmom.NewPxPyPzE(0, 0, 0, mtop)
+		// Two-body decay of the top at rest, with the W along x
+		pw = math.Sqrt((mtop*mtop-(mw+mb)*(mw+mb))*(mtop*mtop-(mw-mb)*(mw-mb))) / (2 * mtop)
+		w  = fmom.NewPxPyPzE(pw, 0, 0, math.Sqrt(pw*pw+mw*mw))
+	)
+	for _, tc := range []struct {
+		name string
+		dir  r3.Vec // lepton direction in the W rest frame
+		want float64
+	}{
+		{"along W", r3.Vec{X: 1}, 1},
+		{"against W", r3.Vec{X: -1}, -1},
+		{"transverse", r3.Vec{Y: 1}, 0},
+		{"60 degrees", r3.Vec{X: 0.5, Z: math.Sqrt(3) / 2}, 0.5},
+		{"120 degrees", r3.Vec{X: -0.5, Y: math.Sqrt(3) / 2}, -0.5},
+	} {
+		var (
+			d = tc.dir.Scale(mw / 2)
+			l = fmom.NewPxPyPzE(d.X, d.Y, d.Z, mw/2)
+		)
+		l = *fmom.Boost(&l, fmom.BoostOf(&w)).(*fmom.PxPyPzE)
+		if got := CosThetaStar(top, w, l); math.Abs(got-tc.want) > tol {
+			t.Errorf("%s: invalid cos(theta*): got %g, want %g", tc.name, got, tc.want)
+		}
+	}
+}
+
+// Normalised cos(theta*) distribution for the fractions f0, fl and fr
+func wDensity(x, f0, fl, fr float64) float64 {
+	return 3.0/4*(1-x*x)*f0 + 3.0/8*(1-x)*(1-x)*fl + 3.0/8*(1+x)*(1+x)*fr
+}
+
+func TestWHelicityFit(t *testing.T) {
+	const nbins = 20
+	for _, tc := range []struct {
+		name       string
+		f0, fl, fr float64
+	}{
+		{"SM", 0.7, 0.3, 0},
+		{"right-handed", 0.6, 0.25, 0.15},
+	} {
+		// Bins filled with the density integrated over them (Simpson's
+		// rule is exact for the quadratic density)
+		var (
+			h    = NewWHelicity(nbins)
+			norm = 1e4
+		)
+		for i := 0; i < nbins; i++ {
+			var (
+				lo = -1 + 2*float64(i)/nbins
+				hi = lo + 2.0/nbins
+				x  = (lo + hi) / 2
+				n  = norm * (hi - lo) / 6 * (wDensity(lo, tc.f0, tc.fl, tc.fr) +
+					4*wDensity(x, tc.f0, tc.fl, tc.fr) + wDensity(hi, tc.f0, tc.fl, tc.fr))
+			)
+			if n > 0 {
+				h.Fill(x, n)
+			}
+		}
+		fit, err := h.Fit()
+		if err != nil {
+			t.Fatalf("%s: could not fit: %+v", tc.name, err)
+		}
+		for _, r := range []struct {
+			res  Result
+			want float64
+		}{
+			{fit.F0, tc.f0},
+			{fit.FL, tc.fl},
+			{fit.FR, tc.fr},
+			{Result{Name: "norm", Value: fit.Norm / norm}, 1},
+		} {
+			if math.Abs(r.res.Value-r.want) > 1e-9 {
+				t.Errorf("%s: invalid %s: got %g, want %g", tc.name, r.res.Name, r.res.Value, r.want)
+			}
+		}
+		if fit.Chi2 > 1e-12 {
+			t.Errorf("%s: invalid chi2: got %g, want 0", tc.name, fit.Chi2)
+		}
+
+		// Leptons drawn from the density, by rejection
+		var (
+			rnd = rand.New(rand.NewSource(1))
+			s   = NewWHelicity(nbins)
+		)
+		for n := 0; n < 200000; {
+			x := 2*rnd.Float64() - 1
+			if 1.5*rnd.Float64() < wDensity(x, tc.f0, tc.fl, tc.fr) {
+				s.Fill(x, 1)
+				n++
+			}
+		}
+		fit, err = s.Fit()
+		if err != nil {
+			t.Fatalf("%s: could not fit sampled leptons: %+v", tc.name, err)
+		}
+		for _, r := range []struct {
+			res  Result
+			want float64
+		}{
+			{fit.F0, tc.f0},
+			{fit.FL, tc.fl},
+			{fit.FR, tc.fr},
+		} {
+			if math.Abs(r.res.Value-r.want) > 4*r.res.Error {
+				t.Errorf("%s: invalid sampled %s: got %.4f +/- %.4f, want %g", tc.name, r.res.Name, r.res.Value, r.res.Error, r.want)
+			}
+		}
+		if sum := fit.F0.Value + fit.FL.Value + fit.FR.Value; math.Abs(sum-1) > tol {
+			t.Errorf("%s: invalid sum of the fractions: got %g, want 1", tc.name, sum)
+		}
+		if fit.Ndf != nbins-3 || fit.Chi2 > 2*float64(fit.Ndf) {
+			t.Errorf("%s: invalid chi2: got %.1f / %d, want about %d", tc.name, fit.Chi2, fit.Ndf, nbins-3)
+		}
+	}
+
+	// Undefined cosines are skipped, and too few bins cannot be fitted
+	h := NewWHelicity(nbins)
+	if h.Fill(math.NaN(), 1) || h.Fill(1.5, 1) || h.Skipped != 2 {
+		t.Errorf("invalid cosines not skipped: %d skipped, want 2", h.Skipped)
+	}
+	h.Fill(-0.5, 1)
+	h.Fill(0.5, 1)
+	if _, err := h.Fit(); err == nil {
+		t.Errorf("expected an error fitting two bins")
+	}
+}
@@ -0,0 +1,390 @@
+// Package unfold corrects binned distributions for the detector
+// response, using either the iterative Bayesian method of D'Agostini
+// [NIM A362 (1995) 487] or a matrix inversion with an optional
+// Tikhonov regularisation of the curvature.
+package unfold
+
+import (
+	"fmt"
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Response relates the truth and reconstructed values of an observable,
+// both binned with Bins bins in [Min, Max]. Events lost by the
+// reconstruction only enter the truth distribution, which defines the
+// efficiency of each truth bin.
+type Response struct {
+	Bins     int
+	Min, Max float64
+
+	Truth       []float64   // sum of weights of the truth distribution
+	Reco        []float64   // sum of weights of the reconstructed distribution
+	RecoW2      []float64   // sum of squared weights of the reconstructed distribution
+	Migration   [][]float64 // sum of weights in [reco bin][truth bin]
+	MigrationW2 [][]float64 // sum of squared weights in [reco bin][truth bin]
+	Lost        []float64   // sum of weights of the lost events, per truth bin
+	LostW2      []float64   // sum of squared weights of the lost events
+}
+
+// NewResponse returns an empty response
+func NewResponse(bins int, min, max float64) *Response {
+	r := &Response{
+		Bins:        bins,
+		Min:         min,
+		Max:         max,
+		Truth:       make([]float64, bins),
+		Reco:        make([]float64, bins),
+		RecoW2:      make([]float64, bins),
+		Migration:   make([][]float64, bins),
+		MigrationW2: make([][]float64, bins),
+		Lost:        make([]float64, bins),
+		LostW2:      make([]float64, bins),
+	}
+	for i := range r.Migration {
+		r.Migration[i] = make([]float64, bins)
+		r.MigrationW2[i] = make([]float64, bins)
+	}
+	return r
+}
+
+// Bin returns the bin index of x, or -1 when x is outside of the range
+func (r *Response) Bin(x float64) int {
+	if math.IsNaN(x) || x < r.Min || x > r.Max {
+		return -1
+	}
+	i := int(float64(r.Bins) * (x - r.Min) / (r.Max - r.Min))
+	if i == r.Bins {
+		i--
+	}
+	return i
+}
+
+// Fill adds a reconstructed event with weight w. Events with an
+// undefined truth value are ignored, and events with a reconstructed
+// value out of range are counted as lost.
+func (r *Response) Fill(truth, reco, w float64) {
+	it := r.Bin(truth)
+	if it < 0 {
+		return
+	}
+	ir := r.Bin(reco)
+	if ir < 0 {
+		r.Miss(truth, w)
+		return
+	}
+	r.Truth[it] += w
+	r.Reco[ir] += w
+	r.RecoW2[ir] += w * w
+	r.Migration[ir][it] += w
+	r.MigrationW2[ir][it] += w * w
+}
+
+// Miss adds an event which was not reconstructed
+func (r *Response) Miss(truth, w float64) {
+	if it := r.Bin(truth); it >= 0 {
+		r.Truth[it] += w
+		r.Lost[it] += w
+		r.LostW2[it] += w * w
+	}
+}
+
+// Merge adds the content of o, which must have the same binning
+func (r *Response) Merge(o *Response) {
+	for i := range r.Truth {
+		r.Truth[i] += o.Truth[i]
+		r.Reco[i] += o.Reco[i]
+		r.RecoW2[i] += o.RecoW2[i]
+		r.Lost[i] += o.Lost[i]
+		r.LostW2[i] += o.LostW2[i]
+		for j := range r.Migration[i] {
+			r.Migration[i][j] += o.Migration[i][j]
+			r.MigrationW2[i][j] += o.MigrationW2[i][j]
+		}
+	}
+}
+
+// Efficiency returns the fraction of reconstructed events of each truth bin
+func (r *Response) Efficiency() []float64 {
+	eff := make([]float64, r.Bins)
+	for j := range eff {
+		if r.Truth[j] <= 0 {
+			continue
+		}
+		for i := range r.Migration {
+			eff[j] += r.Migration[i][j]
+		}
+		eff[j] /= r.Truth[j]
+	}
+	return eff
+}
+
+// Probabilities returns the matrix P(reco bin i | truth bin j),
+// including the efficiency
+func (r *Response) Probabilities() *mat.Dense {
+	p := mat.NewDense(r.Bins, r.Bins, nil)
+	for i := range r.Migration {
+		for j, m := range r.Migration[i] {
+			if r.Truth[j] > 0 {
+				p.Set(i, j, m/r.Truth[j])
+			}
+		}
+	}
+	return p
+}
+
+// Result is an unfolded distribution with the covariance matrices due
+// to the statistical uncertainties of the data and of the response
+type Result struct {
+	Values  []float64
+	CovData *mat.SymDense
+	CovResp *mat.SymDense
+}
+
+// Cov returns the total covariance matrix
+func (res Result) Cov() *mat.SymDense {
+	var cov mat.SymDense
+	cov.AddSym(res.CovData, res.CovResp)
+	return &cov
+}
+
+// Errors returns the square root of the diagonal of the total covariance
+func (res Result) Errors() []float64 {
+	var (
+		cov  = res.Cov()
+		errs = make([]float64, len(res.Values))
+	)
+	for i := range errs {
+		errs[i] = math.Sqrt(math.Max(cov.At(i, i), 0))
+	}
+	return errs
+}
+
+// Chi2 compares the unfolded distribution with a reference, using the
+// full covariance matrix, or only its diagonal when it is singular
+func (res Result) Chi2(ref []float64) (chi2 float64, ndf int) {
+	var (
+		n   = len(res.Values)
+		cov = res.Cov()
+		d   = mat.NewVecDense(n, nil)
+	)
+	for i := range res.Values {
+		d.SetVec(i, res.Values[i]-ref[i])
+	}
+
+	var inv mat.Dense
+	if err := inv.Inverse(cov); err == nil {
+		var tmp mat.VecDense
+		tmp.MulVec(&inv, d)
+		return mat.Dot(d, &tmp), n
+	}
+	for i := 0; i < n; i++ {
+		if v := cov.At(i, i); v > 0 {
+			chi2 += d.AtVec(i) * d.AtVec(i) / v
+			ndf++
+		}
+	}
+	return chi2, ndf
+}
+
+// Bayes unfolds data with niter iterations of the D'Agostini method,
+// starting from the truth distribution of the response as prior
+func Bayes(r *Response, data, dataW2 []float64, niter int) (Result, error) {
+	return run(r, data, dataW2, func(r *Response, d []float64) ([]float64, error) {
+		var (
+			p     = r.Probabilities()
+			eff   = r.Efficiency()
+			prior = normalize(r.Truth)
+			x     = make([]float64, r.Bins)
+		)
+		for it := 0; it < niter; it++ {
+			for j := range x {
+				x[j] = 0
+			}
+			for i := range d {
+				var den float64
+				for k := range prior {
+					den += p.At(i, k) * prior[k]
+				}
+				if den <= 0 {
+					continue
+				}
+				for j := range x {
+					x[j] += d[i] * p.At(i, j) * prior[j] / den
+				}
+			}
+			for j := range x {
+				if eff[j] > 0 {
+					x[j] /= eff[j]
+				}
+			}
+			prior = normalize(x)
+		}
+		return x, nil
+	})
+}
+
+// Tikhonov unfolds data by minimising
+//
+//	(P x - d)^T V^-1 (P x - d) + tau^2 |L x/t|^2
+//
+// where P is the response probability matrix, V = diag(dataW2), t the
+// truth distribution of the response and L the second derivative, so
+// that tau regularises the curvature of the ratio to the truth. With
+// tau = 0, it is a plain (least squares) matrix inversion.
+func Tikhonov(r *Response, data, dataW2 []float64, tau float64) (Result, error) {
+	n := r.Bins
+	w := mat.NewDiagDense(n, nil)
+	for i, v := range dataW2 {
+		if v > 0 {
+			w.SetDiag(i, 1/v)
+		}
+	}
+
+	return run(r, data, dataW2, func(r *Response, d []float64) ([]float64, error) {
+		var (
+			p = r.Probabilities()
+			l = mat.NewDense(max(n-2, 1), n, nil)
+		)
+		for i := 0; i+2 < n; i++ {
+			for j, c := range []float64{1, -2, 1} {
+				if t := r.Truth[i+j]; t > 0 {
+					l.Set(i, i+j, c/t)
+				}
+			}
+		}
+
+		// x = (P^T W P + tau^2 L^T L)^-1 P^T W d
+		var ptw, a, reg, inv mat.Dense
+		ptw.Mul(p.T(), w)
+		a.Mul(&ptw, p)
+		reg.Mul(l.T(), l)
+		reg.Scale(tau*tau, &reg)
+		a.Add(&a, &reg)
+		err := inv.Inverse(&a)
+		if err != nil {
+			return nil, fmt.Errorf("unfold: singular system (tau=%g): %w", tau, err)
+		}
+		var b mat.Dense
+		b.Mul(&inv, &ptw)
+
+		x := mat.NewVecDense(n, nil)
+		x.MulVec(&b, mat.NewVecDense(n, append([]float64(nil), d...)))
+		return x.RawVector().Data, nil
+	})
+}
+
+// Unfold data with f, and propagate linearly (with numerical derivatives)
+// the statistical uncertainties of the data bins, with variances dataW2,
+// and of the response, whose cells (migrations and lost events) are
+// taken as independent
+func run(r *Response, data, dataW2 []float64, f func(r *Response, d []float64) ([]float64, error)) (Result, error) {
+	x, err := f(r, data)
+	if err != nil {
+		return Result{}, err
+	}
+	data = append([]float64(nil), data...)
+
+	// Derivative of the unfolded distribution for a shift h of a quantity
+	derivative := func(shift func(h float64), v float64) ([]float64, error) {
+		h := 1e-4 * (math.Abs(v) + 1)
+		shift(h)
+		up, err := f(r, data)
+		shift(-2 * h)
+		if err == nil {
+			var down []float64
+			down, err = f(r, data)
+			for j := range up {
+				up[j] = (up[j] - down[j]) / (2 * h)
+			}
+		}
+		shift(h)
+		return up, err
+	}
+
+	// Statistical uncertainties of the data
+	jac := mat.NewDense(r.Bins, len(data), nil)
+	for i := range data {
+		i := i
+		dx, err := derivative(func(h float64) { data[i] += h }, data[i])
+		if err != nil {
+			return Result{}, err
+		}
+		jac.SetCol(i, dx)
+	}
+	covData := propagate(jac, dataW2)
+
+	// Statistical uncertainties of the response: each non-empty cell
+	// is shifted together with the truth distribution
+	var (
+		cols [][]float64
+		vars []float64
+	)
+	for i := -1; i < r.Bins; i++ {
+		for j := range r.Truth {
+			cell, w2 := &r.Lost[j], r.LostW2[j]
+			if i >= 0 {
+				cell, w2 = &r.Migration[i][j], r.MigrationW2[i][j]
+			}
+			if w2 <= 0 {
+				continue
+			}
+			j := j
+			dx, err := derivative(func(h float64) { *cell += h; r.Truth[j] += h }, *cell)
+			if err != nil {
+				return Result{}, err
+			}
+			cols = append(cols, dx)
+			vars = append(vars, w2)
+		}
+	}
+	covResp := mat.NewSymDense(r.Bins, nil)
+	if len(cols) > 0 {
+		jac = mat.NewDense(r.Bins, len(cols), nil)
+		for k, c := range cols {
+			jac.SetCol(k, c)
+		}
+		covResp = propagate(jac, vars)
+	}
+
+	return Result{Values: x, CovData: covData, CovResp: covResp}, nil
+}
+
+// Covariance J diag(v) J^T
+func propagate(jac mat.Matrix, v []float64) *mat.SymDense {
+	n, _ := jac.Dims()
+	cov := mat.NewSymDense(n, nil)
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			var c float64
+			for k := range v {
+				c += jac.At(i, k) * v[k] * jac.At(j, k)
+			}
+			cov.SetSym(i, j, c)
+		}
+	}
+	return cov
+}
+
+func normalize(x []float64) []float64 {
+	var sum float64
+	for _, v := range x {
+		sum += v
+	}
+	out := make([]float64, len(x))
+	if sum <= 0 {
+		return out
+	}
+	for i, v := range x {
+		out[i] = v / sum
+	}
+	return out
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
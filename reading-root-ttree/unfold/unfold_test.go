@@ -0,0 +1,220 @@
+package unfold
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Response of unit weight events, with the migrations P[i][j] t[j]
+// from the probabilities p of reconstructing a truth bin j in i
+func newTestResponse(p [][]float64, t []float64) *Response {
+	r := NewResponse(len(t), -1, 1)
+	for j, tj := range t {
+		r.Truth[j] = tj
+		r.Lost[j] = tj
+		for i := range p {
+			m := p[i][j] * tj
+			r.Migration[i][j] = m
+			r.MigrationW2[i][j] = m
+			r.Reco[i] += m
+			r.RecoW2[i] += m
+			r.Lost[j] -= m
+		}
+		r.LostW2[j] = r.Lost[j]
+	}
+	return r
+}
+
+// Reconstructed distribution p t of the truth t
+func fold(p [][]float64, t []float64) []float64 {
+	d := make([]float64, len(p))
+	for i := range p {
+		for j, tj := range t {
+			d[i] += p[i][j] * tj
+		}
+	}
+	return d
+}
+
+var (
+	identity = [][]float64{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+
+	// Migrations to the neighbouring bins, with efficiencies of 75-80%
+	smearing = [][]float64{
+		{0.60, 0.10, 0.00, 0.00},
+		{0.15, 0.60, 0.10, 0.00},
+		{0.00, 0.10, 0.60, 0.15},
+		{0.00, 0.00, 0.10, 0.60},
+	}
+)
+
+// Unfolding methods under test
+var methods = []struct {
+	name   string
+	unfold func(r *Response, data, dataW2 []float64) (Result, error)
+}{
+	{"bayes", func(r *Response, d, w2 []float64) (Result, error) { return Bayes(r, d, w2, 100) }},
+	{"inversion", func(r *Response, d, w2 []float64) (Result, error) { return Tikhonov(r, d, w2, 0) }},
+	{"tikhonov", func(r *Response, d, w2 []float64) (Result, error) { return Tikhonov(r, d, w2, 1e-3) }},
+}
+
+func TestUnfoldTruth(t *testing.T) {
+	var (
+		prior = []float64{40000, 50000, 50000, 40000}
+		truth = []float64{30000, 50000, 60000, 30000}
+	)
+	for _, resp := range []struct {
+		name string
+		p    [][]float64
+		tol  float64
+	}{
+		{"identity", identity, 1e-9},
+		{"smearing", smearing, 1e-3},
+	} {
+		data := fold(resp.p, truth)
+		for _, m := range methods {
+			r := newTestResponse(resp.p, prior)
+			res, err := m.unfold(r, data, data)
+			if err != nil {
+				t.Fatalf("%s, %s: could not unfold: %+v", resp.name, m.name, err)
+			}
+			for j, v := range res.Values {
+				if math.Abs(v-truth[j]) > resp.tol*truth[j] {
+					t.Errorf("%s, %s: invalid bin %d: got %g, want %g", resp.name, m.name, j, v, truth[j])
+				}
+			}
+		}
+	}
+
+	// Without migrations, a flat ratio to the prior is not regularised
+	var (
+		r    = newTestResponse(identity, prior)
+		data = fold(identity, []float64{20000, 25000, 25000, 20000})
+	)
+	res, err := Tikhonov(r, data, data, 100)
+	if err != nil {
+		t.Fatalf("could not unfold: %+v", err)
+	}
+	for j, v := range res.Values {
+		if math.Abs(v-data[j]) > 1e-6*data[j] {
+			t.Errorf("regularised identity: invalid bin %d: got %g, want %g", j, v, data[j])
+		}
+	}
+}
+
+// Covariance of toy results
+func toyCov(xs [][]float64) *mat.SymDense {
+	var (
+		n    = len(xs[0])
+		mean = make([]float64, n)
+		cov  = mat.NewSymDense(n, nil)
+	)
+	for _, x := range xs {
+		for i, v := range x {
+			mean[i] += v / float64(len(xs))
+		}
+	}
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			var c float64
+			for _, x := range xs {
+				c += (x[i] - mean[i]) * (x[j] - mean[j])
+			}
+			cov.SetSym(i, j, c/float64(len(xs)-1))
+		}
+	}
+	return cov
+}
+
+// Compare the propagated covariance to the spread of the toys: 10% on
+// the variances and 0.1 on the correlations, for 2000 toys
+func checkCov(t *testing.T, name string, got, toys *mat.SymDense) {
+	t.Helper()
+
+	n := got.Symmetric()
+	for i := 0; i < n; i++ {
+		if v, w := got.At(i, i), toys.At(i, i); math.Abs(v-w) > 0.1*w {
+			t.Errorf("%s: invalid variance of bin %d: got %g, toys %g", name, i, v, w)
+		}
+		for j := 0; j < i; j++ {
+			var (
+				rho  = got.At(i, j) / math.Sqrt(got.At(i, i)*got.At(j, j))
+				want = toys.At(i, j) / math.Sqrt(toys.At(i, i)*toys.At(j, j))
+			)
+			if math.Abs(rho-want) > 0.1 {
+				t.Errorf("%s: invalid correlation of bins %d and %d: got %.3f, toys %.3f", name, i, j, rho, want)
+			}
+		}
+	}
+}
+
+func TestUnfoldCovariance(t *testing.T) {
+	const ntoys = 2000
+	var (
+		prior = []float64{40000, 50000, 50000, 40000}
+		truth = []float64{30000, 50000, 60000, 30000}
+		rnd   = rand.New(rand.NewSource(1))
+	)
+	for _, resp := range []struct {
+		name string
+		p    [][]float64
+	}{
+		{"identity", identity},
+		{"smearing", smearing},
+	} {
+		data := fold(resp.p, truth)
+		for _, m := range methods {
+			name := resp.name + ", " + m.name
+			res, err := m.unfold(newTestResponse(resp.p, prior), data, data)
+			if err != nil {
+				t.Fatalf("%s: could not unfold: %+v", name, err)
+			}
+
+			// Data fluctuated within their uncertainties
+			var xs [][]float64
+			for k := 0; k < ntoys; k++ {
+				toy := make([]float64, len(data))
+				for i, d := range data {
+					toy[i] = d + rnd.NormFloat64()*math.Sqrt(d)
+				}
+				x, err := m.unfold(newTestResponse(resp.p, prior), toy, toy)
+				if err != nil {
+					t.Fatalf("%s: could not unfold toy %d: %+v", name, k, err)
+				}
+				xs = append(xs, x.Values)
+			}
+			checkCov(t, name+", data", res.CovData, toyCov(xs))
+
+			// Response cells fluctuated within their uncertainties
+			if resp.name == "identity" {
+				continue
+			}
+			xs = xs[:0]
+			for k := 0; k < ntoys; k++ {
+				r := newTestResponse(resp.p, prior)
+				for j := range r.Truth {
+					r.Lost[j] += rnd.NormFloat64() * math.Sqrt(r.LostW2[j])
+					r.Truth[j] = r.Lost[j]
+					for i := range r.Migration {
+						r.Migration[i][j] += rnd.NormFloat64() * math.Sqrt(r.MigrationW2[i][j])
+						r.Truth[j] += r.Migration[i][j]
+					}
+				}
+				x, err := m.unfold(r, data, data)
+				if err != nil {
+					t.Fatalf("%s: could not unfold toy %d: %+v", name, k, err)
+				}
+				xs = append(xs, x.Values)
+			}
+			checkCov(t, name+", response", res.CovResp, toyCov(xs))
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Unfolding of the reconstructed cosines back to the parton level
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"go-hep.org/x/hep/hbook"
+	"go-hep.org/x/hep/hplot"
+	"gonum.org/v1/plot/vg"
+
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/spin"
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/unfold"
+)
+
+// Responses of the six cosines. Even events build the response matrix
+// and odd events are unfolded as pseudo-data, so that the closure test
+// is statistically independent.
+type unfolding struct {
+	train [6]*unfold.Response
+	test  [6]*unfold.Response
+}
+
+func newUnfolding() *unfolding {
+	u := &unfolding{}
+	for i := range u.train {
+		u.train[i] = unfold.NewResponse(nbins, -1, 1)
+		u.test[i] = unfold.NewResponse(nbins, -1, 1)
+	}
+	return u
+}
+
+// Fill the responses with the truth and, if ok, reconstructed cosines of an event
+func (u *unfolding) fill(ievt int64, truth, rec spin.Cosines, ok bool, w float64) {
+	resp := &u.train
+	if ievt%2 == 1 {
+		resp = &u.test
+	}
+	var (
+		ct = [6]float64{truth.KP, truth.RP, truth.NP, truth.KM, truth.RM, truth.NM}
+		cr = [6]float64{rec.KP, rec.RP, rec.NP, rec.KM, rec.RM, rec.NM}
+	)
+	for i, r := range resp {
+		if ok {
+			r.Fill(ct[i], cr[i], w)
+		} else {
+			r.Miss(ct[i], w)
+		}
+	}
+}
+
+func (u *unfolding) merge(o *unfolding) {
+	for i := range u.train {
+		u.train[i].Merge(o.train[i])
+		u.test[i].Merge(o.test[i])
+	}
+}
+
+// Unfolded distribution of a cosine, with the truth and reconstructed
+// distributions of the pseudo-data for the closure test
+type unfolded struct {
+	name   string
+	method string
+	res    unfold.Result
+	truth  []float64
+	reco   []float64
+	chi2   float64
+	ndf    int
+}
+
+// Unfold the pseudo-data of each cosine with the D'Agostini method
+// (niter iterations) and the regularised matrix inversion (tau)
+func (u *unfolding) run(niter int, tau float64) ([]unfolded, error) {
+	method := "inversion"
+	if tau > 0 {
+		method = "tikhonov"
+	}
+
+	var out []unfolded
+	for i, name := range recoCosNames {
+		var (
+			resp = u.train[i]
+			data = u.test[i]
+		)
+		bayes, err := unfold.Bayes(resp, data.Reco, data.RecoW2, niter)
+		if err != nil {
+			return nil, fmt.Errorf("could not unfold %s: %w", name, err)
+		}
+		inv, err := unfold.Tikhonov(resp, data.Reco, data.RecoW2, tau)
+		if err != nil {
+			return nil, fmt.Errorf("could not unfold %s: %w", name, err)
+		}
+		for _, m := range []struct {
+			method string
+			res    unfold.Result
+		}{{"bayes", bayes}, {method, inv}} {
+			chi2, ndf := m.res.Chi2(data.Truth)
+			out = append(out, unfolded{
+				name:   name,
+				method: m.method,
+				res:    m.res,
+				truth:  data.Truth,
+				reco:   data.Reco,
+				chi2:   chi2,
+				ndf:    ndf,
+			})
+		}
+	}
+	return out, nil
+}
+
+// Book the response matrices, unfolded distributions and their
+// covariance in the unfolding directory
+func (hs *histos) addUnfolding(u *unfolding, res []unfolded) {
+	for i, name := range recoCosNames {
+		h := hbook.NewH2D(nbins, -1, 1, nbins, -1, 1)
+		h.Ann["name"] = "response_" + name
+		r := u.train[i]
+		for ir := range r.Migration {
+			for it, m := range r.Migration[ir] {
+				h.Fill(binCenter(it), binCenter(ir), m)
+			}
+		}
+		hs.list = append(hs.list, &histo{dir: "unfolding", xlabel: name + " (truth)", ylabel: name + " (reco)", h2: h})
+	}
+
+	for _, un := range res {
+		name := un.name + "_" + un.method
+		h := newH1DFrom("unfolded_"+name, un.res.Values, un.res.Errors())
+		hs.list = append(hs.list, &histo{dir: "unfolding", xlabel: un.name + " (unfolded)", ylabel: "Events", h1: h})
+
+		var (
+			cov = un.res.Cov()
+			hc  = hbook.NewH2D(nbins, -1, 1, nbins, -1, 1)
+		)
+		hc.Ann["name"] = "cov_" + name
+		for i := range un.res.Values {
+			for j := range un.res.Values {
+				hc.Fill(binCenter(i), binCenter(j), cov.At(i, j))
+			}
+		}
+		hs.list = append(hs.list, &histo{dir: "unfolding", xlabel: un.name, ylabel: un.name, h2: hc})
+	}
+}
+
+// H1D in [-1, 1] with the given bin contents and uncertainties
+func newH1DFrom(name string, values, errs []float64) *hbook.H1D {
+	h := hbook.NewH1D(len(values), -1, 1)
+	h.Ann["name"] = name
+	for i, v := range values {
+		setBin(h, i, v, errs[i])
+	}
+	return h
+}
+
+// Center of the i-th cosine bin
+func binCenter(i int) float64 {
+	return -1 + (float64(i)+0.5)*2/nbins
+}
+
+// Print the closure tests and store the unfolded distributions in a CSV file
+func reportUnfolding(res []unfolded, fname string) error {
+	fmt.Println(" --> Unfolding closure (odd events unfolded with the response of even events)")
+	for _, un := range res {
+		fmt.Printf("     %-8s %-9s: chi2/ndf = %.1f/%d\n", un.name, un.method, un.chi2, un.ndf)
+	}
+
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"name", "method", "bin", "low", "high", "value", "error", "truth", "reco"})
+	for _, un := range res {
+		errs := un.res.Errors()
+		for i, v := range un.res.Values {
+			low := binCenter(i) - 1.0/nbins
+			w.Write([]string{
+				un.name,
+				un.method,
+				strconv.Itoa(i),
+				strconv.FormatFloat(low, 'g', 6, 64),
+				strconv.FormatFloat(low+2.0/nbins, 'g', 6, 64),
+				strconv.FormatFloat(v, 'g', -1, 64),
+				strconv.FormatFloat(errs[i], 'g', -1, 64),
+				strconv.FormatFloat(un.truth[i], 'g', -1, 64),
+				strconv.FormatFloat(un.reco[i], 'g', -1, 64),
+			})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Plot the closure tests into <odir>/unfolding/closure_<cosine>_<method>.pdf:
+// truth, reconstructed and unfolded distributions, and unfolded/truth
+func plotClosure(res []unfolded, odir string) error {
+	err := os.MkdirAll(filepath.Join(odir, "unfolding"), 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, un := range res {
+		var (
+			name  = un.name + "_" + un.method
+			none  = make([]float64, len(un.truth))
+			truth = newH1DFrom("truth", un.truth, none)
+			reco  = newH1DFrom("reco", un.reco, none)
+			unf   = newH1DFrom("unfolded", un.res.Values, un.res.Errors())
+		)
+
+		rp := hplot.NewRatioPlot()
+		rp.Top.Title.Text = fmt.Sprintf("%s, %s (chi2/ndf = %.1f/%d)", un.name, un.method, un.chi2, un.ndf)
+		rp.Top.Y.Label.Text = "Events"
+		for _, h := range []struct {
+			name string
+			h    *hbook.H1D
+			c    color.Color
+			err  bool
+		}{
+			{"truth", truth, color.RGBA{B: 255, A: 255}, false},
+			{"reco", reco, color.RGBA{R: 255, A: 255}, false},
+			{"unfolded", unf, color.Black, true},
+		} {
+			hh := hplot.NewH1D(h.h, hplot.WithYErrBars(h.err))
+			hh.LineStyle.Color = h.c
+			rp.Top.Add(hh)
+			rp.Top.Legend.Add(h.name, hh)
+		}
+		rp.Top.Legend.Top = true
+		rp.Top.Add(hplot.NewGrid())
+
+		var (
+			pts  = make([]hbook.Point2D, len(un.truth))
+			errs = un.res.Errors()
+		)
+		for i, t := range un.truth {
+			x := truth.Binning.Bins[i].XMid()
+			if t <= 0 {
+				pts[i] = hbook.Point2D{X: x, Y: 1}
+				continue
+			}
+			pts[i] = hbook.Point2D{
+				X:    x,
+				Y:    un.res.Values[i] / t,
+				ErrY: hbook.Range{Min: errs[i] / t, Max: errs[i] / t},
+			}
+		}
+		ratio := hplot.NewS2D(hbook.NewS2D(pts...), hplot.WithYErrBars(true))
+		rp.Bottom.Add(ratio, hplot.NewGrid())
+		rp.Bottom.X.Label.Text = un.name
+		rp.Bottom.Y.Label.Text = "unfolded/truth"
+		rp.Bottom.Y.Min, rp.Bottom.Y.Max = 0.5, 1.5
+		rp.Bottom.Y.Tick.Marker = hplot.Ticks{N: 3}
+		rp.Ratio = 0.35
+
+		fname := filepath.Join(odir, "unfolding", "closure_"+name+".pdf")
+		err := hplot.Save(rp, 10*vg.Centimeter, 12*vg.Centimeter, fname)
+		if err != nil {
+			return fmt.Errorf("could not save plot %q: %w", fname, err)
+		}
+	}
+	return nil
+}
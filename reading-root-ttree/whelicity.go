@@ -0,0 +1,81 @@
+// W-helicity fractions from the cos(theta*) distribution of the leptons
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+
+	"gonum.org/v1/plot/vg"
+
+	"go-hep.org/x/hep/hplot"
+
+	"github.com/rmadar/go-simple-examples/reading-root-ttree/spin"
+)
+
+// Add the cos(theta*) distribution of the leptons of both tops to the
+// whelicity directory
+func (hs *histos) addWHelicity(wh *spin.WHelicity) {
+	values, errs := wh.Values()
+	h := newH1DFrom("cos_thetastar", values, errs)
+	hs.list = append(hs.list, &histo{dir: "whelicity", xlabel: "cos(theta*)", ylabel: "Leptons", h1: h})
+}
+
+// Fit the W-helicity fractions and plot the fitted distribution in
+// <odir>/whelicity/fit.pdf. The fractions are reported with the spin
+// coefficients.
+func reportWHelicity(wh *spin.WHelicity, odir string) (spin.WHelicityFit, error) {
+	fit, err := wh.Fit()
+	if err != nil {
+		return fit, err
+	}
+	fmt.Printf(" --> W-helicity fit: chi2/ndf = %.1f/%d (%d leptons with undefined cos(theta*) skipped)\n",
+		fit.Chi2, fit.Ndf, wh.Skipped)
+
+	err = os.MkdirAll(filepath.Join(odir, "whelicity"), 0755)
+	if err != nil {
+		return fit, err
+	}
+	values, errs := wh.Values()
+	var (
+		h     = newH1DFrom("cos_thetastar", values, errs)
+		width = 2 / float64(len(values))
+		p     = hplot.New()
+	)
+	p.Title.Text = fmt.Sprintf("F_0 = %.3f, F_L = %.3f, F_R = %.3f", fit.F0.Value, fit.FL.Value, fit.FR.Value)
+	p.X.Label.Text = "cos(theta*)"
+	p.Y.Label.Text = "Leptons"
+	hh := hplot.NewH1D(h, hplot.WithYErrBars(true))
+	p.Add(hh)
+	p.Legend.Add("data", hh)
+	for _, c := range []struct {
+		name string
+		f    func(x float64) float64
+		c    color.Color
+	}{
+		{"fit", fit.Density, color.Black},
+		{"F_0", func(x float64) float64 { return fit.Norm * 3.0 / 4 * (1 - x*x) * fit.F0.Value }, color.RGBA{B: 255, A: 255}},
+		{"F_L", func(x float64) float64 { return fit.Norm * 3.0 / 8 * (1 - x) * (1 - x) * fit.FL.Value }, color.RGBA{R: 255, A: 255}},
+		{"F_R", func(x float64) float64 { return fit.Norm * 3.0 / 8 * (1 + x) * (1 + x) * fit.FR.Value }, color.RGBA{G: 160, A: 255}},
+	} {
+		f := c.f
+		l := hplot.NewFunction(func(x float64) float64 { return width * f(x) })
+		l.LineStyle.Color = c.c
+		if c.name == "fit" {
+			l.LineStyle.Dashes = []vg.Length{vg.Points(4), vg.Points(2)}
+		}
+		p.Add(l)
+		p.Legend.Add(c.name, l)
+	}
+	p.Legend.Top = true
+	p.Y.Min = 0
+	p.Add(hplot.NewGrid())
+
+	fname := filepath.Join(odir, "whelicity", "fit.pdf")
+	err = p.Save(10*vg.Centimeter, 8*vg.Centimeter, fname)
+	if err != nil {
+		return fit, fmt.Errorf("could not save plot %q: %w", fname, err)
+	}
+	return fit, nil
+}